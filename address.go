@@ -0,0 +1,100 @@
+package rrdcached
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultPort is the port rrdcached listens on when an address does not name one.
+const DefaultPort = 42217
+
+// AddressEnv is the environment variable rrdtool reads the daemon's address from.
+const AddressEnv = "RRDCACHED_ADDRESS"
+
+// Connect connects to address, given as to rrdtool's --daemon option:
+//
+//	unix:/var/run/rrdcached.sock
+//	/var/run/rrdcached.sock
+//	example.com
+//	example.com:42217
+//	[2001:db8::1]:42217
+//	2001:db8::1
+//
+// Addresses without a port use DefaultPort.
+func Connect(address string) (*Rrdcached, error) {
+	return ConnectContext(context.Background(), address)
+}
+
+// ConnectContext is Connect, giving up on the dial once ctx is done.
+func ConnectContext(ctx context.Context, address string) (*Rrdcached, error) {
+	driver, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	err = driver.connect(ctx)
+	return driver, err
+}
+
+// ConnectFromEnv connects to the address in RRDCACHED_ADDRESS, as rrdtool does when --daemon is not given.
+func ConnectFromEnv() (*Rrdcached, error) {
+	address := os.Getenv(AddressEnv)
+	if address == "" {
+		return nil, fmt.Errorf("%s is not set", AddressEnv)
+	}
+	return Connect(address)
+}
+
+// parseAddress follows rrd_client.c: a path is a unix socket, a bracketed host may be
+// followed by a port, and an unbracketed host only has a port if it contains a single
+// colon, so that a bare IPv6 address is not split.
+func parseAddress(address string) (*Rrdcached, error) {
+	if strings.HasPrefix(address, "unix:") || strings.HasPrefix(address, "/") {
+		socket := strings.TrimPrefix(address, "unix:")
+		if socket == "" {
+			return nil, fmt.Errorf("address %q has no socket path", address)
+		}
+		return &Rrdcached{Protocol: "unix", Socket: socket, Rrdio: &dataTransport{}}, nil
+	}
+
+	host, port := address, ""
+	if strings.HasPrefix(address, "[") {
+		end := strings.Index(address, "]")
+		if end < 0 {
+			return nil, fmt.Errorf("address %q is missing ']'", address)
+		}
+		host = address[1:end]
+		rest := address[end+1:]
+		if rest != "" {
+			if !strings.HasPrefix(rest, ":") {
+				return nil, fmt.Errorf("address %q has garbage after ']'", address)
+			}
+			port = rest[1:]
+			if port == "" {
+				return nil, fmt.Errorf("address %q has an empty port", address)
+			}
+		}
+	} else if strings.Count(address, ":") == 1 {
+		colon := strings.Index(address, ":")
+		host, port = address[:colon], address[colon+1:]
+		if port == "" {
+			return nil, fmt.Errorf("address %q has an empty port", address)
+		}
+	}
+	if host == "" {
+		return nil, fmt.Errorf("address %q has no host", address)
+	}
+
+	portNumber := int64(DefaultPort)
+	if port != "" {
+		var err error
+		portNumber, err = strconv.ParseInt(port, 10, 64)
+		if err != nil || portNumber < 1 || portNumber > 65535 {
+			return nil, fmt.Errorf("address %q has invalid port %q", address, port)
+		}
+	}
+
+	return &Rrdcached{Protocol: "tcp", Ip: host, Port: portNumber, Rrdio: &dataTransport{}}, nil
+}
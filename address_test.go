@@ -0,0 +1,90 @@
+package rrdcached
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		address  string
+		protocol string
+		socket   string
+		ip       string
+		port     int64
+	}{
+		{"unix:/var/run/rrdcached.sock", "unix", "/var/run/rrdcached.sock", "", 0},
+		{"unix:relative.sock", "unix", "relative.sock", "", 0},
+		{"/var/run/rrdcached.sock", "unix", "/var/run/rrdcached.sock", "", 0},
+		{"localhost", "tcp", "", "localhost", 42217},
+		{"localhost:50081", "tcp", "", "localhost", 50081},
+		{"127.0.0.1", "tcp", "", "127.0.0.1", 42217},
+		{"127.0.0.1:50081", "tcp", "", "127.0.0.1", 50081},
+		{"[::1]", "tcp", "", "::1", 42217},
+		{"[::1]:50081", "tcp", "", "::1", 50081},
+		{"[2001:db8::1]:50081", "tcp", "", "2001:db8::1", 50081},
+		{"::1", "tcp", "", "::1", 42217},
+		{"2001:db8::1", "tcp", "", "2001:db8::1", 42217},
+	}
+
+	for _, test := range tests {
+		t.Run(test.address, func(t *testing.T) {
+			driver, err := parseAddress(test.address)
+			if assert.NoError(t, err) {
+				assert.Equal(t, test.protocol, driver.Protocol)
+				assert.Equal(t, test.socket, driver.Socket)
+				assert.Equal(t, test.ip, driver.Ip)
+				assert.Equal(t, test.port, driver.Port)
+			}
+		})
+	}
+}
+
+func TestParseAddressInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"unix:",
+		":50081",
+		"localhost:",
+		"localhost:rrdcached",
+		"localhost:0",
+		"localhost:65536",
+		"[::1",
+		"[::1]50081",
+		"[::1]:",
+		"[]:50081",
+	}
+
+	for _, address := range tests {
+		t.Run(address, func(t *testing.T) {
+			_, err := parseAddress(address)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestConnectFromEnv(t *testing.T) {
+	socket := startFakeDaemon(t, func(n int, conn net.Conn, in *bufio.Reader) {
+		in.ReadString('\n')
+	})
+	t.Setenv(AddressEnv, "unix:"+socket)
+
+	driver, err := ConnectFromEnv()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "unix", driver.Protocol)
+	assert.Equal(t, socket, driver.Socket)
+	assert.NotNil(t, driver.Conn)
+}
+
+func TestConnectFromEnvUnset(t *testing.T) {
+	t.Setenv(AddressEnv, "")
+
+	driver, err := ConnectFromEnv()
+
+	assert.Nil(t, driver)
+	assert.Error(t, err)
+}
@@ -0,0 +1,158 @@
+package rrdcached
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+)
+
+// Future is the eventual reply to a command sent by UpdateAsync.
+type Future struct {
+	done chan struct{}
+	resp *Response
+	err  error
+	sent sentCommand
+}
+
+func newFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+func (f *Future) resolve(resp *Response, err error) *Future {
+	f.resp = resp
+	f.err = err
+	close(f.done)
+	return f
+}
+
+// Done is closed once the reply has arrived, or the command has failed.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait blocks until the reply has arrived and returns it, as Update would.
+func (f *Future) Wait() (*Response, error) {
+	<-f.done
+	return f.resp, f.err
+}
+
+// UpdateAsync sends UPDATE without waiting for its reply, so that many updates can be in
+// flight at once on a slow link. rrdcached answers the commands of a connection in order,
+// so a single reader goroutine hands the replies to the futures in the order they were sent.
+//
+// Commands sent this way are not retried by the Reconnect policy. If the connection breaks,
+// every command still waiting for its reply fails with the same error. The transport must
+// implement ReadData without sharing state with WriteData, as reads and writes overlap.
+// Under WithTimestampGuard, the first update of each file waits for the reply to its LAST.
+func (r *Rrdcached) UpdateAsync(filename string, values ...string) *Future {
+	values, send, err := r.guardUpdate(context.Background(), filename, values)
+	if err != nil {
+		return newFuture().resolve(nil, err)
+	}
+	if !send {
+		resp := guardedResponse
+		return newFuture().resolve(&resp, nil)
+	}
+
+	args := append([]string{filename}, values...)
+	if len(r.interceptors) == 0 {
+		return r.sendAsync("UPDATE", args)
+	}
+
+	// The interceptors wait for the reply on a goroutine of their own, but are entered
+	// before the command is written, so that they time it from when it was sent.
+	sent := make(chan *Future, 1)
+	go func() {
+		resp, err := r.invoke(context.Background(), "UPDATE", args, func(ctx context.Context, command string, args []string) (*Response, error) {
+			f := r.sendAsync(command, args)
+			sent <- f
+			return f.Wait()
+		})
+		// An interceptor that did not call next still answers the caller.
+		select {
+		case sent <- newFuture().resolve(resp, err):
+		default:
+		}
+	}()
+	return <-sent
+}
+
+func (r *Rrdcached) sendAsync(command string, args []string) *Future {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f := newFuture()
+	r.sent = sentCommand{command: command, start: time.Now()}
+	err := r.writeCommand(command, args...)
+	f.sent = r.sent
+	if err != nil {
+		r.logFailure(err)
+		return f.resolve(nil, err)
+	}
+
+	r.asyncMu.Lock()
+	defer r.asyncMu.Unlock()
+	r.inflight = append(r.inflight, f)
+	if r.drained == nil {
+		r.drained = make(chan struct{})
+		go r.readAsync(r.Conn, r.stream(), r.drained)
+	}
+	return f
+}
+
+// readAsync reads the replies to the commands in flight until there are none left.
+func (r *Rrdcached) readAsync(conn net.Conn, in io.Reader, drained chan struct{}) {
+	for {
+		r.asyncMu.Lock()
+		if len(r.inflight) == 0 {
+			r.drained = nil
+			close(drained)
+			r.asyncMu.Unlock()
+			return
+		}
+		f := r.inflight[0]
+		r.asyncMu.Unlock()
+
+		var resp *Response
+		r.armDeadline(r.readTimeout, net.Conn.SetReadDeadline)
+		data, err := r.Rrdio.ReadData(in)
+		if err == nil {
+			resp, err = parseResponse(f.sent, data)
+			r.log(LevelDebug, "reply", f.sent.fields("status", resp.Status)...)
+		}
+		if err != nil {
+			r.log(LevelWarn, "command failed", f.sent.fields("error", err)...)
+			r.guardFailed(f.sent.filename, err)
+		}
+
+		r.asyncMu.Lock()
+		r.inflight = r.inflight[1:]
+		if resp == nil {
+			// Without the reply, the replies that follow cannot be matched to their commands.
+			for _, queued := range r.inflight {
+				queued.resolve(nil, err)
+			}
+			r.inflight = nil
+			if conn != nil {
+				conn.Close()
+			}
+		}
+		r.asyncMu.Unlock()
+
+		f.resolve(resp, err)
+	}
+}
+
+// lock takes the connection for a command that reads its own reply,
+// once the replies to the commands sent by UpdateAsync have been read.
+func (r *Rrdcached) lock() {
+	r.mu.Lock()
+
+	r.asyncMu.Lock()
+	drained := r.drained
+	r.asyncMu.Unlock()
+	if drained != nil {
+		<-drained
+	}
+}
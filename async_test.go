@@ -0,0 +1,146 @@
+package rrdcached
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// echoInBatches reads size commands before answering any of them, with the command itself,
+// so a client that waits for each reply before sending the next command never gets one.
+func echoInBatches(size int) func(n int, conn net.Conn, in *bufio.Reader) {
+	return func(n int, conn net.Conn, in *bufio.Reader) {
+		var pending []string
+		for {
+			line, err := in.ReadString('\n')
+			if err != nil {
+				return
+			}
+			pending = append(pending, strings.TrimSpace(line))
+			if len(pending) < size {
+				continue
+			}
+			for _, command := range pending {
+				fmt.Fprintf(conn, "0 %s\n", command)
+			}
+			pending = nil
+		}
+	}
+}
+
+func TestUpdateAsync(t *testing.T) {
+	socket := startFakeDaemon(t, echoInBatches(10))
+	driver, err := ConnectToSocket(socket)
+	assert.NoError(t, err)
+
+	var futures []*Future
+	for i := 0; i < 100; i++ {
+		futures = append(futures, driver.UpdateAsync("foo.rrd", fmt.Sprintf("%d:1", i)))
+	}
+
+	for i, f := range futures {
+		resp, err := f.Wait()
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("UPDATE foo.rrd %d:1", i), resp.Message)
+	}
+}
+
+func TestUpdateAsyncThenSync(t *testing.T) {
+	socket := startFakeDaemon(t, echoInBatches(1))
+	driver, err := ConnectToSocket(socket)
+	assert.NoError(t, err)
+
+	first := driver.UpdateAsync("foo.rrd", "1438354679:1")
+	second := driver.UpdateAsync("foo.rrd", "1438354680:2")
+
+	// FLUSH waits for the replies to the updates, and must not take one of them for its own.
+	resp, err := driver.Flush("foo.rrd")
+	assert.NoError(t, err)
+	assert.Equal(t, "FLUSH foo.rrd", resp.Message)
+
+	for _, f := range []*Future{first, second} {
+		select {
+		case <-f.Done():
+		default:
+			t.Error("update still pending after FLUSH")
+		}
+	}
+	resp, err = second.Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, "UPDATE foo.rrd 1438354680:2", resp.Message)
+}
+
+func TestUpdateAsyncConnectionLost(t *testing.T) {
+	socket := startFakeDaemon(t, func(n int, conn net.Conn, in *bufio.Reader) {
+		in.ReadString('\n')
+		conn.Write([]byte("0 errors, enqueued 1 value(s).\n"))
+		in.ReadString('\n')
+	})
+	driver, err := ConnectToSocket(socket)
+	assert.NoError(t, err)
+
+	first := driver.UpdateAsync("foo.rrd", "1438354679:1")
+	second := driver.UpdateAsync("foo.rrd", "1438354680:2")
+	third := driver.UpdateAsync("foo.rrd", "1438354681:3")
+
+	resp, err := first.Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, resp.Status)
+	_, err = second.Wait()
+	assert.IsType(t, &ConnectionError{}, err)
+	_, err = third.Wait()
+	assert.IsType(t, &ConnectionError{}, err)
+}
+
+func TestUpdateAsyncNotConnected(t *testing.T) {
+	driver := &Rrdcached{Rrdio: &dataTransport{}}
+
+	_, err := driver.UpdateAsync("foo.rrd", "1438354679:1").Wait()
+
+	assert.IsType(t, &ConnectionError{}, err)
+}
+
+func TestUpdateAsyncInterceptors(t *testing.T) {
+	socket := startFakeDaemon(t, echoInBatches(1))
+	metrics := NewMetrics()
+	entered := make(chan struct{})
+	driver, err := New(socket, WithInterceptors(metrics.Intercept, func(ctx context.Context, command string, args []string, next Invoker) (*Response, error) {
+		close(entered)
+		return next(ctx, command, args)
+	}))
+	assert.NoError(t, err)
+
+	f := driver.UpdateAsync("foo.rrd", "1438354679:1")
+
+	// The interceptors were entered before the command was written, so their latency covers all of it.
+	select {
+	case <-entered:
+	default:
+		t.Error("UpdateAsync returned before the interceptors were entered")
+	}
+	_, err = f.Wait()
+	assert.NoError(t, err)
+
+	// The interceptors run on their own goroutine, and may finish after Wait returns.
+	assert.Eventually(t, func() bool {
+		return metrics.Snapshot()[MetricKey{"UPDATE", "ok"}].Count == 1
+	}, time.Second, time.Millisecond)
+}
+
+func TestUpdateAsyncInterceptorWithoutNext(t *testing.T) {
+	driver := &Rrdcached{Rrdio: &dataTransport{}, interceptors: []Interceptor{
+		func(ctx context.Context, command string, args []string, next Invoker) (*Response, error) {
+			return nil, ErrIllegalUpdate
+		},
+	}}
+
+	_, err := driver.UpdateAsync("foo.rrd", "1438354679:1").Wait()
+
+	assert.Equal(t, ErrIllegalUpdate, err)
+}
@@ -0,0 +1,233 @@
+package rrdcached
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Batch collects commands to be sent in a single BATCH round trip.
+// The daemon only replies once, after the terminating dot, so a batch
+// costs one round trip however many commands it carries.
+type Batch struct {
+	exec     func(ctx context.Context, commands []batchCommand) (*Response, error)
+	commands []batchCommand
+}
+
+// batchCommand is kept apart from its arguments until Exec, so that a TimestampGuard can check the values of updates.
+type batchCommand struct {
+	command  string
+	filename string
+	values   []string
+}
+
+func (c batchCommand) line() (string, error) {
+	return commandLine(c.command, append([]string{c.filename}, c.values...))
+}
+
+// BatchCommandError is the daemon's complaint about one command of a batch.
+// Line is the 1-based position of the command within the batch as it was built, counting
+// updates that a TimestampGuard left out. Command is the line that was sent for it.
+type BatchCommandError struct {
+	Line    int
+	Command string
+	Message string
+}
+
+func (f *BatchCommandError) Error() string {
+	return fmt.Sprintf("batch command %d (%s): %s", f.Line, f.Command, f.Message)
+}
+
+// Unwrap returns the complaint as a ServerError, so that errors.Is classifies it.
+func (f *BatchCommandError) Unwrap() error {
+	fields := splitCommandLine(f.Command)
+	return &ServerError{
+		Command:  fields[0],
+		Filename: commandFilename(fields[0], fields[1:]),
+		Message:  f.Message,
+	}
+}
+
+// BatchError is returned by Exec when any command of the batch failed.
+// Commands that are not listed were accepted.
+type BatchError struct {
+	Errors []*BatchCommandError
+}
+
+func (f *BatchError) Error() string {
+	messages := make([]string, len(f.Errors))
+	for i, err := range f.Errors {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("%d batch command(s) failed: %s", len(f.Errors), strings.Join(messages, "; "))
+}
+
+// Unwrap lets errors.Is and errors.As look through the errors of every failed command.
+func (f *BatchError) Unwrap() []error {
+	errs := make([]error, len(f.Errors))
+	for i, err := range f.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+func (r *Rrdcached) Batch() *Batch {
+	return &Batch{exec: r.execBatch}
+}
+
+func (b *Batch) Update(filename string, values ...string) *Batch {
+	return b.add(batchCommand{"UPDATE", filename, values})
+}
+
+func (b *Batch) Flush(filename string) *Batch {
+	return b.add(batchCommand{"FLUSH", filename, nil})
+}
+
+func (b *Batch) Forget(filename string) *Batch {
+	return b.add(batchCommand{"FORGET", filename, nil})
+}
+
+func (b *Batch) Wrote(filename string) *Batch {
+	return b.add(batchCommand{"WROTE", filename, nil})
+}
+
+func (b *Batch) add(command batchCommand) *Batch {
+	b.commands = append(b.commands, command)
+	return b
+}
+
+func (b *Batch) Len() int {
+	return len(b.commands)
+}
+
+// Exec sends the collected commands and resets the batch, so it can be reused.
+func (b *Batch) Exec() (*Response, error) {
+	return b.ExecContext(context.Background())
+}
+
+func (b *Batch) ExecContext(ctx context.Context) (*Response, error) {
+	commands := b.commands
+	b.commands = nil
+	return b.exec(ctx, commands)
+}
+
+// execBatch sends the commands of a batch. Under WithTimestampGuard, updates left without
+// values are not sent, and a rejected update fails the batch before anything is sent.
+func (r *Rrdcached) execBatch(ctx context.Context, batch []batchCommand) (*Response, error) {
+	lines := make([]string, 0, len(batch))
+	// positions holds the 1-based position within batch of each line sent.
+	positions := make([]int, 0, len(batch))
+	var guarded []string
+	// The updates checked by the guard so far will not be sent either.
+	unguard := func() {
+		for _, filename := range guarded {
+			r.guard.forget(filename)
+		}
+	}
+	for i, c := range batch {
+		if c.command == "UPDATE" && r.guard != nil {
+			values, send, err := r.guardUpdate(ctx, c.filename, c.values)
+			if err != nil {
+				unguard()
+				return nil, err
+			}
+			guarded = append(guarded, c.filename)
+			if !send {
+				continue
+			}
+			c.values = values
+		}
+		line, err := c.line()
+		if err != nil {
+			unguard()
+			return nil, err
+		}
+		lines = append(lines, line)
+		positions = append(positions, i+1)
+	}
+
+	resp, err := r.invoke(ctx, "BATCH", lines, func(ctx context.Context, command string, commands []string) (*Response, error) {
+		r.lock()
+		defer r.mu.Unlock()
+
+		var resp *Response
+		err := r.withReconnect(ctx, command, func() (err error) {
+			resp, err = r.roundTrip(command)
+			return err
+		})
+		if err != nil {
+			return resp, err
+		}
+
+		var result *Response
+		err = r.withContext(ctx, func() (err error) {
+			err = r.write(strings.Join(append(commands, ".\n"), "\n"))
+			if err != nil {
+				return err
+			}
+			result, err = r.checkResponse()
+			return err
+		})
+		if err != nil {
+			r.logFailure(err)
+			if _, broken := err.(*ConnectionError); broken && r.Reconnect != nil {
+				// The payload is not resent, as the daemon may have applied part of it,
+				// but the next command redials as it would after withReconnect.
+				r.disconnect()
+			}
+			return result, err
+		}
+
+		if len(commands) != len(positions) {
+			// An interceptor changed the commands, so their positions are those sent.
+			return result, parseBatchErrors(result, commands, nil)
+		}
+		return result, parseBatchErrors(result, commands, positions)
+	})
+
+	var batchErr *BatchError
+	switch {
+	case errors.As(err, &batchErr):
+		for _, cmdErr := range batchErr.Errors {
+			if serverErr := cmdErr.Unwrap().(*ServerError); serverErr.Command == "UPDATE" {
+				r.guardFailed(serverErr.Filename, serverErr)
+			}
+		}
+	case err != nil:
+		// The batch may not have reached the daemon, so none of its updates are known to be applied.
+		unguard()
+	}
+	return resp, err
+}
+
+// parseBatchErrors reads the complaints in the reply to a batch. positions, if not nil,
+// maps the 1-based index of each command sent to its position within the batch.
+func parseBatchErrors(resp *Response, commands []string, positions []int) error {
+	if resp.Status <= 0 {
+		return nil
+	}
+
+	batchErr := &BatchError{}
+	for _, line := range strings.Split(resp.Raw, "\n")[1:] {
+		field := strings.SplitN(strings.TrimSpace(line), " ", 2)
+		if len(field) != 2 {
+			continue
+		}
+		index, err := strconv.Atoi(field[0])
+		if err != nil {
+			continue
+		}
+
+		cmdErr := &BatchCommandError{Line: index, Message: field[1]}
+		if index >= 1 && index <= len(commands) {
+			cmdErr.Command = commands[index-1]
+			if positions != nil {
+				cmdErr.Line = positions[index-1]
+			}
+		}
+		batchErr.Errors = append(batchErr.Errors, cmdErr)
+	}
+	return batchErr
+}
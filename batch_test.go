@@ -0,0 +1,93 @@
+package rrdcached
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSequenceTransport answers each read with the next canned response,
+// for commands that take more than one round trip.
+type fakeSequenceTransport struct {
+	written   []string
+	responses []string
+}
+
+func (rrdio *fakeSequenceTransport) WriteData(conn net.Conn, data string) error {
+	rrdio.written = append(rrdio.written, data)
+	return nil
+}
+
+func (rrdio *fakeSequenceTransport) ReadData(r io.Reader) (string, error) {
+	if len(rrdio.responses) == 0 {
+		return "", io.EOF
+	}
+	response := rrdio.responses[0]
+	rrdio.responses = rrdio.responses[1:]
+	return response, nil
+}
+
+func prepSequenceTestData(fakeResponses ...string) (transport *fakeSequenceTransport, fakeDriver *Rrdcached) {
+	transport = &fakeSequenceTransport{responses: fakeResponses}
+	return transport, &Rrdcached{Rrdio: transport}
+}
+
+func TestBatch(t *testing.T) {
+	transport, fakeDriver := prepSequenceTestData(
+		"0 Go ahead.  End with dot '.' on its own line.",
+		"0 errors",
+	)
+
+	batch := fakeDriver.Batch().
+		Update("foo.rrd", "1438354679:10:20", "1438354680:90:80").
+		Update("bar.rrd", "1438354679:1:2").
+		Flush("foo.rrd").
+		Wrote("foo.rrd").
+		Forget("bar.rrd")
+	assert.Equal(t, 5, batch.Len())
+
+	resp, err := batch.Exec()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, resp.Status)
+	assert.Equal(t, 0, batch.Len())
+	assert.Equal(t, []string{
+		"BATCH\n",
+		"UPDATE foo.rrd 1438354679:10:20 1438354680:90:80\nUPDATE bar.rrd 1438354679:1:2\nFLUSH foo.rrd\nWROTE foo.rrd\nFORGET bar.rrd\n.\n",
+	}, transport.written)
+}
+
+func TestBatchWithErrors(t *testing.T) {
+	_, fakeDriver := prepSequenceTestData(
+		"0 Go ahead.  End with dot '.' on its own line.",
+		"2 errors\n1 No such file: /tmp/foo.rrd\n3 illegal attempt to update using time 1438354679 when last update time is 1438354680 (minimum one second step)",
+	)
+
+	resp, err := fakeDriver.Batch().
+		Update("foo.rrd", "1438354679:10:20").
+		Update("bar.rrd", "1438354680:1:2").
+		Update("bar.rrd", "1438354679:1:2").
+		Exec()
+
+	assert.Equal(t, 2, resp.Status)
+	if assert.IsType(t, &BatchError{}, err) {
+		errs := err.(*BatchError).Errors
+		assert.Equal(t, []*BatchCommandError{
+			{Line: 1, Command: "UPDATE foo.rrd 1438354679:10:20", Message: "No such file: /tmp/foo.rrd"},
+			{Line: 3, Command: "UPDATE bar.rrd 1438354679:1:2", Message: "illegal attempt to update using time 1438354679 when last update time is 1438354680 (minimum one second step)"},
+		}, errs)
+	}
+}
+
+func TestBatchUnsupported(t *testing.T) {
+	transport, fakeDriver := prepSequenceTestData(
+		"-1 Unknown command: BATCH",
+	)
+
+	_, err := fakeDriver.Batch().Update("foo.rrd", "1438354679:10:20").Exec()
+
+	assert.IsType(t, &UnknownCommandError{}, err)
+	assert.Equal(t, []string{"BATCH\n"}, transport.written)
+}
@@ -0,0 +1,145 @@
+package rrdcached
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Capabilities lists the commands a daemon understands, as reported by HELP.
+type Capabilities struct {
+	// Commands maps each command name to its syntax line, e.g. "FLUSH" to "FLUSH <filename>".
+	Commands map[string]string
+}
+
+type UnsupportedCommandError struct {
+	Command string
+	Err     error
+}
+
+func (f *UnsupportedCommandError) Error() string {
+	return f.Err.Error()
+}
+
+func (f *UnsupportedCommandError) Unwrap() error {
+	return f.Err
+}
+
+// Is matches ErrUnknownCommand, which the daemon would have replied had the command been sent.
+func (f *UnsupportedCommandError) Is(target error) bool {
+	return target == ErrUnknownCommand
+}
+
+func (c *Capabilities) Supports(command string) bool {
+	_, ok := c.Commands[strings.ToUpper(command)]
+	return ok
+}
+
+// CreateNoOverwrite reports whether CREATE accepts -O, which was added after CREATE itself.
+func (c *Capabilities) CreateNoOverwrite() bool {
+	for _, field := range strings.Fields(c.Commands["CREATE"]) {
+		if strings.Trim(field, "[]") == "-O" {
+			return true
+		}
+	}
+	return false
+}
+
+// baseCommands are understood by every rrdcached, so sending them needs no probe.
+var baseCommands = map[string]bool{
+	"BATCH":    true,
+	"FLUSH":    true,
+	"FLUSHALL": true,
+	"FORGET":   true,
+	"HELP":     true,
+	"PENDING":  true,
+	"QUEUE":    true,
+	"QUIT":     true,
+	"STATS":    true,
+	"UPDATE":   true,
+}
+
+// Capabilities asks the daemon for its commands once per connection.
+// Commands the daemon lacks fail with UnsupportedCommandError without being sent,
+// and Create leaves out -O if the daemon does not accept it.
+//
+// Commands that not every daemon has probe the connection on their own the first time
+// one of them is sent, so Capabilities is only needed to inspect the result.
+func (r *Rrdcached) Capabilities() (*Capabilities, error) {
+	return r.CapabilitiesContext(context.Background())
+}
+
+func (r *Rrdcached) CapabilitiesContext(ctx context.Context) (*Capabilities, error) {
+	r.mu.Lock()
+	capabilities := r.capabilities
+	r.mu.Unlock()
+	if capabilities != nil {
+		return capabilities, nil
+	}
+
+	resp, err := r.exec(ctx, "HELP")
+	if err != nil {
+		return nil, err
+	}
+
+	capabilities = parseCapabilities(resp)
+	r.mu.Lock()
+	r.capabilities = capabilities
+	r.probed = true
+	r.mu.Unlock()
+	return capabilities, nil
+}
+
+// probedCapabilities probes the connection if it has not been yet, and returns what is
+// known of it: nil if the daemon could not tell.
+func (r *Rrdcached) probedCapabilities(ctx context.Context) (*Capabilities, error) {
+	r.lock()
+	defer r.mu.Unlock()
+
+	err := r.withReconnect(ctx, "HELP", func() error {
+		return r.probe()
+	})
+	return r.capabilities, err
+}
+
+func parseCapabilities(resp *Response) *Capabilities {
+	capabilities := &Capabilities{Commands: map[string]string{}}
+	for _, line := range resp.Lines() {
+		command := strings.ToUpper(strings.Fields(line)[0])
+		capabilities.Commands[command] = line
+	}
+	return capabilities
+}
+
+// probe sends HELP on a connection that has not been probed yet. A daemon that refuses HELP
+// is not probed again, and its commands are sent unchecked. The caller holds the connection.
+func (r *Rrdcached) probe() error {
+	if r.probed || r.Conn == nil {
+		return nil
+	}
+
+	sent := r.sent
+	r.sent = sentCommand{command: "HELP", start: time.Now()}
+	resp, err := r.roundTrip("HELP")
+	r.sent = sent
+
+	var serverErr *ServerError
+	switch {
+	case err == nil:
+		r.capabilities = parseCapabilities(resp)
+	case errors.As(err, &serverErr):
+	default:
+		return err
+	}
+	r.probed = true
+	return nil
+}
+
+func (r *Rrdcached) checkSupported(command string) error {
+	if r.capabilities == nil || command == "HELP" || r.capabilities.Supports(command) {
+		return nil
+	}
+	return &UnsupportedCommandError{command, fmt.Errorf("%s is not supported by this rrdcached", command)}
+}
@@ -0,0 +1,163 @@
+package rrdcached
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testHelpResponse = "6 Command overview\n" +
+	"UPDATE <filename> <values> [<values> ...]\n" +
+	"FLUSH <filename>\n" +
+	"FLUSHALL\n" +
+	"PENDING <filename>\n" +
+	"CREATE <filename> [-b start] [-s step] [-O] <DS definitions> <RRA definitions>\n" +
+	"STATS\n"
+
+const testOldHelpResponse = "3 Command overview\n" +
+	"UPDATE <filename> <values> [<values> ...]\n" +
+	"CREATE <filename> [-b start] [-s step] <DS definitions> <RRA definitions>\n" +
+	"STATS\n"
+
+func TestCapabilities(t *testing.T) {
+	transport, fakeDriver := prepSequenceTestData(testHelpResponse)
+
+	capabilities, err := fakeDriver.Capabilities()
+
+	assert.NoError(t, err)
+	assert.True(t, capabilities.Supports("UPDATE"))
+	assert.True(t, capabilities.Supports("flushall"))
+	assert.False(t, capabilities.Supports("FIRST"))
+	assert.True(t, capabilities.CreateNoOverwrite())
+	assert.Equal(t, "FLUSH <filename>", capabilities.Commands["FLUSH"])
+
+	// The probe is cached for the connection.
+	again, err := fakeDriver.Capabilities()
+	assert.NoError(t, err)
+	assert.Equal(t, capabilities, again)
+	assert.Equal(t, []string{"HELP\n"}, transport.written)
+}
+
+func TestCapabilitiesFailFast(t *testing.T) {
+	transport, fakeDriver := prepSequenceTestData(testHelpResponse)
+	fakeDriver.Capabilities()
+
+	_, err := fakeDriver.First("foo.rrd", 0)
+
+	assert.IsType(t, &UnsupportedCommandError{}, err)
+	assert.Equal(t, "FIRST", err.(*UnsupportedCommandError).Command)
+	assert.Equal(t, []string{"HELP\n"}, transport.written)
+}
+
+func TestCapabilitiesCreateOnOldDaemon(t *testing.T) {
+	transport, fakeDriver := prepSequenceTestData(testOldHelpResponse, "0 RRD created successfully (/tmp/foo.rrd)")
+	fakeDriver.Capabilities()
+
+	resp, err := fakeDriver.Create("foo.rrd", -1, -1, false, testDefineDS, testDefineRRA)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, resp.Status)
+	assert.Equal(t, []string{
+		"HELP\n",
+		"CREATE foo.rrd DS:test1:GAUGE:600:0:100 DS:test2:GAUGE:600:0:100 RRA:MIN:0.5:12:1440 RRA:MAX:0.5:12:1440 RRA:AVERAGE:0.5:1:1440\n",
+	}, transport.written)
+}
+
+func TestCapabilitiesCreateOnNewDaemon(t *testing.T) {
+	transport, fakeDriver := prepSequenceTestData(testHelpResponse, "0 RRD created successfully (/tmp/foo.rrd)")
+	fakeDriver.Capabilities()
+
+	_, err := fakeDriver.Create("foo.rrd", -1, -1, false, testDefineDS, testDefineRRA)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "CREATE foo.rrd -O DS:test1:GAUGE:600:0:100 DS:test2:GAUGE:600:0:100 RRA:MIN:0.5:12:1440 RRA:MAX:0.5:12:1440 RRA:AVERAGE:0.5:1:1440\n", transport.written[1])
+}
+
+func TestCapabilitiesUnprobed(t *testing.T) {
+	expected, fakeDriver := prepTestData(
+		"FIRST foo.rrd 0\n",
+		"0 1438354679",
+	)
+
+	_, err := fakeDriver.First("foo.rrd", 0)
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, fakeDriver.Rrdio)
+}
+
+// answerHelp answers HELP with help and everything else with success, and sends the
+// commands it receives on received. Connections before the nth are hung up on instead.
+func answerHelp(help string, hangUp int, received chan<- string) func(n int, conn net.Conn, in *bufio.Reader) {
+	return func(n int, conn net.Conn, in *bufio.Reader) {
+		for {
+			line, err := in.ReadString('\n')
+			if err != nil || n < hangUp {
+				return
+			}
+			received <- strings.TrimSpace(line)
+			if line == "HELP\n" {
+				conn.Write([]byte(help))
+			} else {
+				conn.Write([]byte("0 Success\n"))
+			}
+		}
+	}
+}
+
+func receivedCommands(received chan string) []string {
+	var commands []string
+	for {
+		select {
+		case command := <-received:
+			commands = append(commands, command)
+		default:
+			return commands
+		}
+	}
+}
+
+func TestCapabilitiesProbedOnce(t *testing.T) {
+	received := make(chan string, 10)
+	driver, err := ConnectToSocket(startFakeDaemon(t, answerHelp(testOldHelpResponse, 0, received)))
+	assert.NoError(t, err)
+
+	_, err = driver.Update("foo.rrd", "1438354679:10")
+	assert.NoError(t, err)
+	_, err = driver.First("foo.rrd", 0)
+	assert.True(t, errors.Is(err, ErrUnknownCommand))
+	_, err = driver.Create("foo.rrd", -1, -1, false, testDefineDS, testDefineRRA)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"UPDATE foo.rrd 1438354679:10",
+		"HELP",
+		"CREATE foo.rrd DS:test1:GAUGE:600:0:100 DS:test2:GAUGE:600:0:100 RRA:MIN:0.5:12:1440 RRA:MAX:0.5:12:1440 RRA:AVERAGE:0.5:1:1440",
+	}, receivedCommands(received))
+}
+
+func TestCapabilitiesWroteProbed(t *testing.T) {
+	received := make(chan string, 10)
+	driver, err := ConnectToSocket(startFakeDaemon(t, answerHelp(testOldHelpResponse, 0, received)))
+	assert.NoError(t, err)
+
+	_, err = driver.Wrote("foo.rrd")
+
+	assert.IsType(t, &UnsupportedCommandError{}, err)
+	assert.Equal(t, []string{"HELP"}, receivedCommands(received))
+}
+
+func TestCapabilitiesProbedAfterReconnect(t *testing.T) {
+	received := make(chan string, 10)
+	driver, err := ConnectToSocket(startFakeDaemon(t, answerHelp(testOldHelpResponse, 1, received)))
+	assert.NoError(t, err)
+	driver.Reconnect = &testReconnectPolicy
+
+	_, err = driver.Last("foo.rrd")
+
+	assert.IsType(t, &UnsupportedCommandError{}, err)
+	assert.Equal(t, []string{"HELP"}, receivedCommands(received))
+}
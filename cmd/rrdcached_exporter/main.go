@@ -0,0 +1,70 @@
+// Command rrdcached_exporter serves the STATS of rrdcached daemons as Prometheus metrics:
+//
+//	rrdcached_exporter -address unix:/var/run/rrdcached.sock -address backup.example.com:42217
+//
+// Without -address, the daemon in RRDCACHED_ADDRESS is scraped.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dreadpirateshawn/rrdcached"
+	"github.com/dreadpirateshawn/rrdcached/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type addressList []string
+
+func (a *addressList) String() string {
+	return strings.Join(*a, ",")
+}
+
+func (a *addressList) Set(address string) error {
+	*a = append(*a, address)
+	return nil
+}
+
+func main() {
+	var addresses addressList
+	flag.Var(&addresses, "address", "rrdcached address, as for rrdtool's --daemon; may be repeated")
+	listen := flag.String("listen", ":9617", "address to serve metrics on")
+	path := flag.String("path", "/metrics", "path to serve metrics on")
+	timeout := flag.Duration("timeout", 5*time.Second, "timeout for each scrape of each daemon")
+	flag.Parse()
+
+	if len(addresses) == 0 {
+		address := os.Getenv(rrdcached.AddressEnv)
+		if address == "" {
+			fmt.Fprintf(os.Stderr, "No -address given and %s is not set.\n", rrdcached.AddressEnv)
+			os.Exit(2)
+		}
+		addresses = append(addresses, address)
+	}
+
+	var targets []collector.Target
+	for _, address := range addresses {
+		// A daemon that is down at startup is reconnected to on a later scrape.
+		client, err := rrdcached.New(address, rrdcached.WithDialTimeout(*timeout), rrdcached.WithReconnect(rrdcached.DefaultReconnectPolicy))
+		if client == nil {
+			log.Fatalf("Invalid address %q: %v", address, err)
+		}
+		if err != nil {
+			log.Printf("Cannot connect to %s yet: %v", address, err)
+		}
+		targets = append(targets, collector.Target{Address: address, Client: client})
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector.New(*timeout, targets...))
+	http.Handle(*path, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	log.Printf("Serving metrics for %s on %s%s", addresses.String(), *listen, *path)
+	log.Fatal(http.ListenAndServe(*listen, nil))
+}
@@ -0,0 +1,146 @@
+// Package collector exports the STATS of one or more rrdcached daemons as Prometheus metrics.
+package collector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/dreadpirateshawn/rrdcached"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "rrdcached"
+
+// StatsReader is the part of rrdcached.Client the collector uses.
+type StatsReader interface {
+	GetStatsContext(ctx context.Context) (*rrdcached.Stats, error)
+}
+
+// Target is a daemon to scrape. Address is only used as the value of the address label.
+type Target struct {
+	Address string
+	Client  StatsReader
+}
+
+type stat struct {
+	name      string
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	value     func(stats *rrdcached.Stats) uint64
+}
+
+func newStat(name string, help string, valueType prometheus.ValueType, value func(stats *rrdcached.Stats) uint64) stat {
+	name = prometheus.BuildFQName(namespace, "", name)
+	return stat{
+		name:      name,
+		desc:      prometheus.NewDesc(name, help, []string{"address"}, nil),
+		valueType: valueType,
+		value:     value,
+	}
+}
+
+// stats maps the fields of rrdcached.Stats to metrics. The daemon resets its counters when it restarts.
+var stats = []stat{
+	newStat("queue_length", "Number of files waiting in the write queue.", prometheus.GaugeValue,
+		func(s *rrdcached.Stats) uint64 { return s.QueueLength }),
+	newStat("creates_received_total", "CREATE commands received.", prometheus.CounterValue,
+		func(s *rrdcached.Stats) uint64 { return s.CreatesReceived }),
+	newStat("updates_received_total", "UPDATE commands received.", prometheus.CounterValue,
+		func(s *rrdcached.Stats) uint64 { return s.UpdatesReceived }),
+	newStat("flushes_received_total", "FLUSH commands received.", prometheus.CounterValue,
+		func(s *rrdcached.Stats) uint64 { return s.FlushesReceived }),
+	newStat("updates_written_total", "Updates written to RRD files.", prometheus.CounterValue,
+		func(s *rrdcached.Stats) uint64 { return s.UpdatesWritten }),
+	newStat("data_sets_written_total", "Data sets written to RRD files.", prometheus.CounterValue,
+		func(s *rrdcached.Stats) uint64 { return s.DataSetsWritten }),
+	newStat("tree_nodes", "Number of nodes in the cache.", prometheus.GaugeValue,
+		func(s *rrdcached.Stats) uint64 { return s.TreeNodesNumber }),
+	newStat("tree_depth", "Depth of the cache tree.", prometheus.GaugeValue,
+		func(s *rrdcached.Stats) uint64 { return s.TreeDepth }),
+	newStat("journal_bytes_total", "Bytes written to the journal.", prometheus.CounterValue,
+		func(s *rrdcached.Stats) uint64 { return s.JournalBytes }),
+	newStat("journal_rotations_total", "Journal rotations.", prometheus.CounterValue,
+		func(s *rrdcached.Stats) uint64 { return s.JournalRotate }),
+}
+
+var (
+	upDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "up"),
+		"Whether the last scrape of the daemon succeeded.", []string{"address"}, nil)
+	scrapeErrorsDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "scrape_errors_total"),
+		"Scrapes of the daemon that failed.", []string{"address"}, nil)
+	scrapeDurationDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "scrape_duration_seconds"),
+		"Time taken by the last scrape of the daemon.", []string{"address"}, nil)
+)
+
+// Collector sends STATS to every target when it is collected, and reports a failed scrape
+// through rrdcached_up and rrdcached_scrape_errors_total rather than failing the collection.
+type Collector struct {
+	timeout time.Duration
+	targets []Target
+
+	mu           sync.Mutex
+	scrapeErrors map[string]uint64
+}
+
+// New scrapes targets, giving up on each after timeout, or never if it is zero.
+func New(timeout time.Duration, targets ...Target) *Collector {
+	return &Collector{
+		timeout:      timeout,
+		targets:      targets,
+		scrapeErrors: map[string]uint64{},
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, s := range stats {
+		ch <- s.desc
+	}
+	ch <- upDesc
+	ch <- scrapeErrorsDesc
+	ch <- scrapeDurationDesc
+}
+
+// Collect scrapes the targets concurrently.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	var wg sync.WaitGroup
+	for _, target := range c.targets {
+		wg.Add(1)
+		go func(target Target) {
+			defer wg.Done()
+			c.collect(ch, target)
+		}(target)
+	}
+	wg.Wait()
+}
+
+func (c *Collector) collect(ch chan<- prometheus.Metric, target Target) {
+	ctx := context.Background()
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	result, err := target.Client.GetStatsContext(ctx)
+	ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, time.Since(start).Seconds(), target.Address)
+
+	c.mu.Lock()
+	if err != nil || result == nil {
+		c.scrapeErrors[target.Address]++
+	}
+	scrapeErrors := c.scrapeErrors[target.Address]
+	c.mu.Unlock()
+	ch <- prometheus.MustNewConstMetric(scrapeErrorsDesc, prometheus.CounterValue, float64(scrapeErrors), target.Address)
+
+	if err != nil || result == nil {
+		ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, 0, target.Address)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, 1, target.Address)
+
+	for _, s := range stats {
+		ch <- prometheus.MustNewConstMetric(s.desc, s.valueType, float64(s.value(result)), target.Address)
+	}
+}
@@ -0,0 +1,120 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/dreadpirateshawn/rrdcached"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// cannedTransport answers every command with the same reply.
+type cannedTransport struct {
+	response string
+}
+
+func (rrdio *cannedTransport) WriteData(conn net.Conn, data string) error {
+	return nil
+}
+
+func (rrdio *cannedTransport) ReadData(r io.Reader) (string, error) {
+	return rrdio.response, nil
+}
+
+const statsReply = `9 Statistics follow
+QueueLength: 3
+UpdatesReceived: 1200
+FlushesReceived: 4
+UpdatesWritten: 1100
+DataSetsWritten: 2200
+TreeNodesNumber: 42
+TreeDepth: 6
+JournalBytes: 65536
+JournalRotate: 2
+`
+
+type failingStats struct{}
+
+func (failingStats) GetStatsContext(ctx context.Context) (*rrdcached.Stats, error) {
+	return nil, &rrdcached.ConnectionError{Err: fmt.Errorf("dial unix /tmp/missing.sock: connect: no such file or directory")}
+}
+
+func TestCollector(t *testing.T) {
+	c := New(0, Target{Address: "unix:/tmp/rrdcached.sock", Client: &rrdcached.Rrdcached{Rrdio: &cannedTransport{statsReply}}})
+
+	err := testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP rrdcached_creates_received_total CREATE commands received.
+# TYPE rrdcached_creates_received_total counter
+rrdcached_creates_received_total{address="unix:/tmp/rrdcached.sock"} 0
+# HELP rrdcached_data_sets_written_total Data sets written to RRD files.
+# TYPE rrdcached_data_sets_written_total counter
+rrdcached_data_sets_written_total{address="unix:/tmp/rrdcached.sock"} 2200
+# HELP rrdcached_flushes_received_total FLUSH commands received.
+# TYPE rrdcached_flushes_received_total counter
+rrdcached_flushes_received_total{address="unix:/tmp/rrdcached.sock"} 4
+# HELP rrdcached_journal_bytes_total Bytes written to the journal.
+# TYPE rrdcached_journal_bytes_total counter
+rrdcached_journal_bytes_total{address="unix:/tmp/rrdcached.sock"} 65536
+# HELP rrdcached_journal_rotations_total Journal rotations.
+# TYPE rrdcached_journal_rotations_total counter
+rrdcached_journal_rotations_total{address="unix:/tmp/rrdcached.sock"} 2
+# HELP rrdcached_queue_length Number of files waiting in the write queue.
+# TYPE rrdcached_queue_length gauge
+rrdcached_queue_length{address="unix:/tmp/rrdcached.sock"} 3
+# HELP rrdcached_scrape_errors_total Scrapes of the daemon that failed.
+# TYPE rrdcached_scrape_errors_total counter
+rrdcached_scrape_errors_total{address="unix:/tmp/rrdcached.sock"} 0
+# HELP rrdcached_tree_depth Depth of the cache tree.
+# TYPE rrdcached_tree_depth gauge
+rrdcached_tree_depth{address="unix:/tmp/rrdcached.sock"} 6
+# HELP rrdcached_tree_nodes Number of nodes in the cache.
+# TYPE rrdcached_tree_nodes gauge
+rrdcached_tree_nodes{address="unix:/tmp/rrdcached.sock"} 42
+# HELP rrdcached_up Whether the last scrape of the daemon succeeded.
+# TYPE rrdcached_up gauge
+rrdcached_up{address="unix:/tmp/rrdcached.sock"} 1
+# HELP rrdcached_updates_received_total UPDATE commands received.
+# TYPE rrdcached_updates_received_total counter
+rrdcached_updates_received_total{address="unix:/tmp/rrdcached.sock"} 1200
+# HELP rrdcached_updates_written_total Updates written to RRD files.
+# TYPE rrdcached_updates_written_total counter
+rrdcached_updates_written_total{address="unix:/tmp/rrdcached.sock"} 1100
+`), namesExceptDuration()...)
+
+	assert.NoError(t, err)
+}
+
+func TestCollectorScrapeError(t *testing.T) {
+	c := New(0,
+		Target{Address: "up.example.com", Client: &rrdcached.Rrdcached{Rrdio: &cannedTransport{statsReply}}},
+		Target{Address: "down.example.com", Client: failingStats{}},
+	)
+
+	// Each collection scrapes again, so the failing daemon has failed twice by the comparison.
+	testutil.CollectAndCount(c)
+	err := testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP rrdcached_scrape_errors_total Scrapes of the daemon that failed.
+# TYPE rrdcached_scrape_errors_total counter
+rrdcached_scrape_errors_total{address="down.example.com"} 2
+rrdcached_scrape_errors_total{address="up.example.com"} 0
+# HELP rrdcached_up Whether the last scrape of the daemon succeeded.
+# TYPE rrdcached_up gauge
+rrdcached_up{address="down.example.com"} 0
+rrdcached_up{address="up.example.com"} 1
+`), "rrdcached_up", "rrdcached_scrape_errors_total")
+
+	assert.NoError(t, err)
+}
+
+func namesExceptDuration() []string {
+	names := []string{"rrdcached_up", "rrdcached_scrape_errors_total"}
+	for _, s := range stats {
+		names = append(names, s.name)
+	}
+	return names
+}
@@ -0,0 +1,108 @@
+package rrdcached
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// aLongTimeAgo is a deadline that has already passed, for interrupting blocked reads and writes.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// withContext runs fn, a command's round trip, bounded by ctx. The caller holds the connection.
+func (r *Rrdcached) withContext(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return &TimeoutError{err}
+	}
+
+	unbind := r.bindContext(ctx)
+	return unbind(fn())
+}
+
+// bindContext makes ctx's deadline the connection's, and interrupts blocked reads and writes
+// when ctx is cancelled. The returned function undoes this, and turns err into a TimeoutError
+// if it was caused by ctx.
+func (r *Rrdcached) bindContext(ctx context.Context) func(err error) error {
+	conn := r.Conn
+	if conn == nil || ctx.Done() == nil {
+		return func(err error) error {
+			r.closeIfTimedOut(conn, err)
+			return err
+		}
+	}
+
+	deadline, _ := ctx.Deadline()
+	r.deadlineMu.Lock()
+	r.deadline = deadline
+	r.cancelled = false
+	conn.SetDeadline(deadline)
+	r.deadlineMu.Unlock()
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			r.deadlineMu.Lock()
+			r.cancelled = true
+			conn.SetDeadline(aLongTimeAgo)
+			r.deadlineMu.Unlock()
+		case <-stop:
+		}
+	}()
+
+	return func(err error) error {
+		close(stop)
+		<-stopped
+
+		r.deadlineMu.Lock()
+		r.deadline = time.Time{}
+		r.cancelled = false
+		conn.SetDeadline(time.Time{})
+		r.deadlineMu.Unlock()
+
+		if err != nil {
+			_, timedOut := err.(*TimeoutError)
+			if ctx.Err() != nil {
+				err = &TimeoutError{ctx.Err()}
+			} else if timedOut && !deadline.IsZero() && !time.Now().Before(deadline) {
+				// The connection's deadline can pass just before ctx notices its own.
+				err = &TimeoutError{context.DeadlineExceeded}
+			}
+		}
+		r.closeIfTimedOut(conn, err)
+		return err
+	}
+}
+
+// armDeadline bounds the next read or write by timeout, unless the command's context ends sooner.
+func (r *Rrdcached) armDeadline(timeout time.Duration, set func(net.Conn, time.Time) error) {
+	if timeout <= 0 || r.Conn == nil {
+		return
+	}
+
+	r.deadlineMu.Lock()
+	defer r.deadlineMu.Unlock()
+	if r.cancelled {
+		return
+	}
+	deadline := time.Now().Add(timeout)
+	if !r.deadline.IsZero() && r.deadline.Before(deadline) {
+		deadline = r.deadline
+	}
+	set(r.Conn, deadline)
+}
+
+// closeIfTimedOut closes the connection after an interrupted command. Its reply may still be
+// on the way, and would be taken for the reply to the next command. The next command fails
+// with a ConnectionError instead, or reconnects if Reconnect is set.
+func (r *Rrdcached) closeIfTimedOut(conn net.Conn, err error) {
+	if _, timedOut := err.(*TimeoutError); !timedOut || conn == nil {
+		return
+	}
+	conn.Close()
+	if r.Conn == conn {
+		r.Conn = nil
+	}
+}
@@ -0,0 +1,156 @@
+package rrdcached
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// neverReply reads commands and leaves them unanswered, like a hung daemon.
+func neverReply(n int, conn net.Conn, in *bufio.Reader) {
+	for {
+		_, err := in.ReadString('\n')
+		if err != nil {
+			return
+		}
+	}
+}
+
+// replyOnce answers the first connection's first command with reply, and ignores everything else.
+func replyOnce(reply string) func(n int, conn net.Conn, in *bufio.Reader) {
+	return func(n int, conn net.Conn, in *bufio.Reader) {
+		for i := 0; ; i++ {
+			_, err := in.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if n == 0 && i == 0 {
+				conn.Write([]byte(reply))
+			}
+		}
+	}
+}
+
+func TestContextDeadline(t *testing.T) {
+	socket := startFakeDaemon(t, neverReply)
+	driver, err := ConnectToSocket(socket)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = driver.FlushContext(ctx, "foo.rrd")
+
+	if assert.IsType(t, &TimeoutError{}, err) {
+		assert.Equal(t, context.DeadlineExceeded, err.(*TimeoutError).Err)
+	}
+	assert.Nil(t, driver.Conn)
+
+	_, err = driver.Flush("foo.rrd")
+	assert.IsType(t, &ConnectionError{}, err)
+}
+
+func TestContextCancel(t *testing.T) {
+	socket := startFakeDaemon(t, neverReply)
+	driver, err := ConnectToSocket(socket)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err = driver.LastContext(ctx, "foo.rrd")
+
+	if assert.IsType(t, &TimeoutError{}, err) {
+		assert.Equal(t, context.Canceled, err.(*TimeoutError).Err)
+	}
+}
+
+func TestContextAlreadyDone(t *testing.T) {
+	_, fakeDriver := prepTestData("", "0 Successfully flushed /tmp/foo.rrd.")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := fakeDriver.FlushContext(ctx, "foo.rrd")
+
+	assert.IsType(t, &TimeoutError{}, err)
+	assert.Equal(t, "", fakeDriver.Rrdio.(*fakeDataTransport).written)
+}
+
+func TestContextDeadlineCleared(t *testing.T) {
+	socket := startFakeDaemon(t, func(n int, conn net.Conn, in *bufio.Reader) {
+		for {
+			_, err := in.ReadString('\n')
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("0 Successfully flushed /tmp/foo.rrd.\n"))
+		}
+	})
+	driver, err := ConnectToSocket(socket)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	resp, err := driver.FlushContext(ctx, "foo.rrd")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, resp.Status)
+
+	// The deadline of the first command must not carry over to the next.
+	time.Sleep(60 * time.Millisecond)
+	resp, err = driver.Flush("foo.rrd")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, resp.Status)
+}
+
+func TestContextReconnectAfterTimeout(t *testing.T) {
+	socket := startFakeDaemon(t, func(n int, conn net.Conn, in *bufio.Reader) {
+		if n == 0 {
+			neverReply(n, conn, in)
+			return
+		}
+		replyOnce("0 Successfully flushed /tmp/foo.rrd.\n")(0, conn, in)
+	})
+	driver, err := ConnectToSocket(socket)
+	assert.NoError(t, err)
+	policy := testReconnectPolicy
+	driver.Reconnect = &policy
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = driver.FlushContext(ctx, "foo.rrd")
+	assert.IsType(t, &TimeoutError{}, err)
+
+	resp, err := driver.Flush("foo.rrd")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, resp.Status)
+}
+
+func TestConnectContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := ConnectToSocketContext(ctx, filepath.Join(t.TempDir(), "rrdcached.sock"))
+
+	assert.IsType(t, &TimeoutError{}, err)
+}
+
+func TestPoolContext(t *testing.T) {
+	dialer := &fakeDialer{}
+	pool, err := NewPool(1, 0, dialer.dial)
+	assert.NoError(t, err)
+	defer pool.Close()
+
+	// Hold the only connection, so the command has to wait for it.
+	r, err := pool.get(context.Background())
+	assert.NoError(t, err)
+	defer pool.put(r, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = pool.FlushContext(ctx, "foo.rrd")
+
+	assert.IsType(t, &TimeoutError{}, err)
+}
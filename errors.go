@@ -0,0 +1,169 @@
+package rrdcached
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// Sentinel errors classifying the daemon's complaints, for use with errors.Is:
+//
+//	_, err := driver.Update(filename, values...)
+//	if errors.Is(err, ErrTimestampTooOld) {
+//		// The file already has an update at or after this time.
+//	}
+var (
+	ErrUnknownCommand       = errors.New("unknown command")
+	ErrNoSuchFile           = errors.New("no such file")
+	ErrUnrecognizedArgument = errors.New("unrecognized argument")
+	ErrPermissionDenied     = errors.New("permission denied")
+	ErrFileExists           = errors.New("file exists")
+	// ErrIllegalUpdate matches every update the daemon refused, including those matching ErrTimestampTooOld.
+	ErrIllegalUpdate   = errors.New("illegal update")
+	ErrTimestampTooOld = errors.New("timestamp too old")
+)
+
+// classify returns the sentinel errors matching a message of the daemon, from rrdcached or librrd.
+func classify(message string) []error {
+	lower := strings.ToLower(message)
+	switch {
+	case strings.HasPrefix(message, "Unknown command"):
+		return []error{ErrUnknownCommand}
+	case strings.Contains(message, "No such file"):
+		return []error{ErrNoSuchFile}
+	case strings.Contains(message, "can't parse argument"):
+		return []error{ErrUnrecognizedArgument}
+	case strings.Contains(lower, "permission denied"):
+		return []error{ErrPermissionDenied}
+	case strings.Contains(lower, "file exists"):
+		return []error{ErrFileExists}
+	case strings.Contains(message, "illegal attempt to update using time"):
+		return []error{ErrIllegalUpdate, ErrTimestampTooOld}
+	case strings.HasPrefix(message, "Cannot find timestamp"),
+		strings.Contains(message, "data source readings"),
+		strings.Contains(message, "found extra data on update argument"),
+		strings.Contains(message, "conversion of"):
+		return []error{ErrIllegalUpdate}
+	}
+	return nil
+}
+
+// ServerError is a command the daemon refused with status -1. The typed errors returned for some
+// messages, such as FileDoesNotExistError, wrap it, so errors.As finds it behind any of them.
+type ServerError struct {
+	Command  string
+	Filename string
+	Message  string
+}
+
+func (f *ServerError) Error() string {
+	return f.Message
+}
+
+// Is reports whether target is one of the sentinel errors classifying Message.
+func (f *ServerError) Is(target error) bool {
+	for _, err := range classify(f.Message) {
+		if err == target {
+			return true
+		}
+	}
+	return false
+}
+
+// newServerError returns the ServerError for message, wrapped in the typed error for its class if there is one.
+func newServerError(sent sentCommand, message string) error {
+	err := &ServerError{Command: sent.command, Filename: sent.filename, Message: message}
+	switch {
+	case errors.Is(err, ErrUnknownCommand):
+		return &UnknownCommandError{err}
+	case errors.Is(err, ErrNoSuchFile):
+		return &FileDoesNotExistError{err}
+	case errors.Is(err, ErrUnrecognizedArgument):
+		return &UnrecognizedArgumentError{err}
+	}
+	return err
+}
+
+type PanicError struct {
+	Err error
+}
+
+func (f *PanicError) Error() string {
+	return f.Err.Error()
+}
+
+func (f *PanicError) Unwrap() error {
+	return f.Err
+}
+
+type ConnectionError struct {
+	Err error
+}
+
+func (f *ConnectionError) Error() string {
+	return f.Err.Error()
+}
+
+func (f *ConnectionError) Unwrap() error {
+	return f.Err
+}
+
+// TimeoutError is returned when a command's context is done before its reply has been read.
+// The connection is closed, since the reply may still arrive and would be taken for the next one.
+type TimeoutError struct {
+	Err error
+}
+
+func (f *TimeoutError) Error() string {
+	return f.Err.Error()
+}
+
+func (f *TimeoutError) Unwrap() error {
+	return f.Err
+}
+
+type UnknownCommandError struct {
+	Err error
+}
+
+func (f *UnknownCommandError) Error() string {
+	return f.Err.Error()
+}
+
+func (f *UnknownCommandError) Unwrap() error {
+	return f.Err
+}
+
+type FileDoesNotExistError struct {
+	Err error
+}
+
+func (f *FileDoesNotExistError) Error() string {
+	return f.Err.Error()
+}
+
+func (f *FileDoesNotExistError) Unwrap() error {
+	return f.Err
+}
+
+type UnrecognizedArgumentError struct {
+	Err error
+}
+
+func (f *UnrecognizedArgumentError) Error() string {
+	return f.Err.Error()
+}
+
+func (f *UnrecognizedArgumentError) Unwrap() error {
+	return f.Err
+}
+
+func (f *UnrecognizedArgumentError) BadArgument() string {
+	re := regexp.MustCompile(`can't parse argument '(.+)'`)
+	matches := re.FindStringSubmatch(f.Error())
+	if matches != nil {
+		return matches[1]
+	} else {
+		return ""
+	}
+}
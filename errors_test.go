@@ -0,0 +1,74 @@
+package rrdcached
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerError(t *testing.T) {
+	_, fakeDriver := prepTestData("", "-1 illegal attempt to update using time 1438354679 when last update time is 1438354680 (minimum one second step)")
+
+	_, err := fakeDriver.Update("foo.rrd", "1438354679:10")
+
+	var serverErr *ServerError
+	if assert.True(t, errors.As(err, &serverErr)) {
+		assert.Equal(t, &ServerError{
+			Command:  "UPDATE",
+			Filename: "foo.rrd",
+			Message:  "illegal attempt to update using time 1438354679 when last update time is 1438354680 (minimum one second step)",
+		}, serverErr)
+	}
+	assert.True(t, errors.Is(err, ErrIllegalUpdate))
+	assert.True(t, errors.Is(err, ErrTimestampTooOld))
+	assert.False(t, errors.Is(err, ErrNoSuchFile))
+}
+
+func TestServerErrorWrapped(t *testing.T) {
+	_, fakeDriver := prepTestData("", "-1 No such file: /tmp/foo.rrd")
+
+	_, err := fakeDriver.Flush("foo.rrd")
+
+	assert.IsType(t, &FileDoesNotExistError{}, err)
+	assert.True(t, errors.Is(err, ErrNoSuchFile))
+	var serverErr *ServerError
+	assert.True(t, errors.As(err, &serverErr))
+	assert.Equal(t, "FLUSH", serverErr.Command)
+}
+
+func TestClassify(t *testing.T) {
+	cases := map[string][]error{
+		"Unknown command: FIRST":                                       {ErrUnknownCommand},
+		"No such file: /tmp/foo.rrd":                                   {ErrNoSuchFile},
+		"RRD Error: opening '/tmp/foo.rrd': No such file or directory": {ErrNoSuchFile},
+		"Error while creating rrd (can't parse argument '-O')":         {ErrUnrecognizedArgument},
+		"Permission denied":                                            {ErrPermissionDenied},
+		"RRD Error: creating '/tmp/foo.rrd': File exists":              {ErrFileExists},
+		"illegal attempt to update using time 1 when last update time is 2 (minimum one second step)": {ErrIllegalUpdate, ErrTimestampTooOld},
+		"Cannot find timestamp in 'soon'!":                              {ErrIllegalUpdate},
+		"expected 4 data source readings (got 2) from 1438354679:10:20": {ErrIllegalUpdate},
+		"Timeout": nil,
+	}
+
+	for message, expected := range cases {
+		assert.Equal(t, expected, classify(message), message)
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	assert.True(t, errors.Is(&TimeoutError{context.DeadlineExceeded}, context.DeadlineExceeded))
+	assert.True(t, errors.Is(&ConnectionError{io.EOF}, io.EOF))
+	assert.True(t, errors.Is(&UnsupportedCommandError{"FIRST", errors.New("FIRST is not supported by this rrdcached")}, ErrUnknownCommand))
+
+	batchErr := &BatchError{Errors: []*BatchCommandError{
+		{Line: 2, Command: "UPDATE bar.rrd 1438354679:1:2", Message: "No such file: /tmp/bar.rrd"},
+	}}
+	assert.True(t, errors.Is(batchErr, ErrNoSuchFile))
+	var serverErr *ServerError
+	if assert.True(t, errors.As(batchErr, &serverErr)) {
+		assert.Equal(t, &ServerError{Command: "UPDATE", Filename: "bar.rrd", Message: "No such file: /tmp/bar.rrd"}, serverErr)
+	}
+}
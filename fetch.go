@@ -0,0 +1,316 @@
+package rrdcached
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// FetchResult holds the time series returned by FETCH.
+// Values is indexed as Values[row][ds], with NaN for unknown values.
+type FetchResult struct {
+	FlushVersion uint64
+	Start        int64
+	End          int64
+	Step         int64
+	DSNames      []string
+	Timestamps   []int64
+	Values       [][]float64
+}
+
+// Fetch reads consolidated data back through the daemon, flushing the file first if necessary.
+// Negative start, end or step values are omitted. rrdcached has no resolution argument for FETCH,
+// so a non-negative step is only checked against the step of the RRA chosen by the daemon.
+func (r *Rrdcached) Fetch(filename string, cf string, start int64, end int64, step int64) (*FetchResult, error) {
+	return r.FetchContext(context.Background(), filename, cf, start, end, step)
+}
+
+func (r *Rrdcached) FetchContext(ctx context.Context, filename string, cf string, start int64, end int64, step int64) (*FetchResult, error) {
+	params, err := fetchParams(filename, cf, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.exec(ctx, "FETCH", params...)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := parseFetch(resp.Raw)
+	if err != nil {
+		return nil, err
+	}
+	return result, checkFetchStep(result, step)
+}
+
+func fetchParams(filename string, cf string, start int64, end int64) ([]string, error) {
+	params := []string{filename, cf}
+	if start >= 0 {
+		params = append(params, strconv.FormatInt(start, 10))
+	}
+	if end >= 0 {
+		if start < 0 {
+			return nil, fmt.Errorf("FETCH requires a start time when an end time is given")
+		}
+		params = append(params, strconv.FormatInt(end, 10))
+	}
+	return params, nil
+}
+
+func checkFetchStep(result *FetchResult, step int64) error {
+	if step >= 0 && result.Step != step {
+		return fmt.Errorf("FETCH returned step %d, expected %d", result.Step, step)
+	}
+	return nil
+}
+
+// parseFetchHeader reports false for lines that are not part of the header.
+func parseFetchHeader(result *FetchResult, dsCount *int, key string, value string) (bool, error) {
+	var err error
+	switch key {
+	case "FlushVersion":
+		result.FlushVersion, err = strconv.ParseUint(value, 10, 64)
+	case "Start":
+		result.Start, err = strconv.ParseInt(value, 10, 64)
+	case "End":
+		result.End, err = strconv.ParseInt(value, 10, 64)
+	case "Step":
+		result.Step, err = strconv.ParseInt(value, 10, 64)
+	case "DSCount":
+		*dsCount, err = strconv.Atoi(value)
+	case "DSName":
+		result.DSNames = strings.Fields(value)
+	default:
+		return false, nil
+	}
+	return true, err
+}
+
+func parseFetch(data string) (*FetchResult, error) {
+	lines := strings.Split(data, "\n")
+
+	result := &FetchResult{}
+	dsCount := -1
+	var rows []string
+
+	for _, line := range lines[1:] {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		field := strings.SplitN(line, ": ", 2)
+		if len(field) != 2 {
+			return nil, fmt.Errorf("FETCH returned malformed line %q", line)
+		}
+
+		header, err := parseFetchHeader(result, &dsCount, field[0], field[1])
+		if err != nil {
+			return nil, fmt.Errorf("FETCH returned malformed line %q: %v", line, err)
+		}
+		if !header {
+			rows = append(rows, line)
+		}
+	}
+
+	if dsCount < 0 {
+		dsCount = len(result.DSNames)
+	}
+	if len(result.DSNames) != dsCount {
+		return nil, fmt.Errorf("FETCH returned %d DS names, expected %d", len(result.DSNames), dsCount)
+	}
+
+	// One backing array for all rows keeps large fetches to a handful of allocations.
+	values := make([]float64, len(rows)*dsCount)
+	result.Timestamps = make([]int64, len(rows))
+	result.Values = make([][]float64, len(rows))
+
+	for i, line := range rows {
+		field := strings.SplitN(line, ": ", 2)
+		timestamp, err := strconv.ParseInt(field[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("FETCH returned malformed line %q: %v", line, err)
+		}
+
+		row := values[i*dsCount : (i+1)*dsCount]
+		cells := strings.Fields(field[1])
+		if len(cells) != dsCount {
+			return nil, fmt.Errorf("FETCH returned %d values at %d, expected %d", len(cells), timestamp, dsCount)
+		}
+		for j, cell := range cells {
+			row[j], err = parseValue(cell)
+			if err != nil {
+				return nil, fmt.Errorf("FETCH returned malformed value %q at %d: %v", cell, timestamp, err)
+			}
+		}
+
+		result.Timestamps[i] = timestamp
+		result.Values[i] = row
+	}
+
+	return result, nil
+}
+
+// parseValue handles the "nan" and "-nan" that C's printf emits for unknown values.
+func parseValue(s string) (float64, error) {
+	switch strings.ToLower(strings.TrimLeft(s, "+-")) {
+	case "nan", "u":
+		return math.NaN(), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// FetchBinary is Fetch over FETCHBIN, which avoids formatting and parsing every value as text.
+// rrdcached answers with the usual header lines followed by one block of raw doubles per DS:
+//
+//	DSName-<name>: BinaryData <records> <record size> <LITTLE|BIG>
+//	<records * record size bytes>
+//
+// The transport must implement RRDStreamIO to read it.
+func (r *Rrdcached) FetchBinary(filename string, cf string, start int64, end int64, step int64) (*FetchResult, error) {
+	return r.FetchBinaryContext(context.Background(), filename, cf, start, end, step)
+}
+
+func (r *Rrdcached) FetchBinaryContext(ctx context.Context, filename string, cf string, start int64, end int64, step int64) (*FetchResult, error) {
+	stream, ok := r.Rrdio.(RRDStreamIO)
+	if !ok {
+		return nil, fmt.Errorf("transport %T cannot read binary replies", r.Rrdio)
+	}
+
+	params, err := fetchParams(filename, cf, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *FetchResult
+	_, err = r.invoke(ctx, "FETCHBIN", params, func(ctx context.Context, command string, args []string) (resp *Response, err error) {
+		r.lock()
+		defer r.mu.Unlock()
+
+		err = r.withReconnect(ctx, command, func() (err error) {
+			result, resp, err = r.fetchBinary(stream, args)
+			return err
+		})
+		return resp, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, checkFetchStep(result, step)
+}
+
+// fetchBinary also returns the status line, which is all of the reply that fits a Response.
+func (r *Rrdcached) fetchBinary(stream RRDStreamIO, params []string) (*FetchResult, *Response, error) {
+	err := r.writeCommand("FETCHBIN", params...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	in := r.stream()
+	line, err := stream.ReadLine(in)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.logReply(line)
+	resp, err := parseResponse(r.sent, line)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	result := &FetchResult{}
+	dsCount := -1
+	rows := -1
+	var block []byte
+	var values []float64
+
+	for i := 0; i < resp.Status; i++ {
+		line, err := stream.ReadLine(in)
+		if err != nil {
+			return nil, resp, err
+		}
+		line = strings.TrimSpace(line)
+		field := strings.SplitN(line, ": ", 2)
+		if len(field) != 2 {
+			return nil, resp, fmt.Errorf("FETCHBIN returned malformed line %q", line)
+		}
+
+		if !strings.HasPrefix(field[1], "BinaryData ") {
+			_, err := parseFetchHeader(result, &dsCount, field[0], field[1])
+			if err != nil {
+				return nil, resp, fmt.Errorf("FETCHBIN returned malformed line %q: %v", line, err)
+			}
+			continue
+		}
+
+		records, order, err := parseBinaryHeader(field[1])
+		if err != nil {
+			return nil, resp, fmt.Errorf("FETCHBIN returned malformed line %q: %v", line, err)
+		}
+		if dsCount < 0 {
+			return nil, resp, fmt.Errorf("FETCHBIN returned data before DSCount")
+		}
+		if rows < 0 {
+			rows = records
+			block = make([]byte, rows*8+1)
+			values = make([]float64, rows*dsCount)
+		} else if records != rows {
+			return nil, resp, fmt.Errorf("FETCHBIN returned %d records for %q, expected %d", records, field[0], rows)
+		}
+
+		ds := len(result.DSNames)
+		if ds >= dsCount {
+			return nil, resp, fmt.Errorf("FETCHBIN returned more than %d data sources", dsCount)
+		}
+		result.DSNames = append(result.DSNames, strings.TrimPrefix(field[0], "DSName-"))
+
+		// The block is terminated by a newline that is not part of the data.
+		err = stream.ReadFull(in, block)
+		if err != nil {
+			return nil, resp, err
+		}
+		for row := 0; row < rows; row++ {
+			values[row*dsCount+ds] = math.Float64frombits(order.Uint64(block[row*8:]))
+		}
+	}
+
+	if rows < 0 {
+		rows = 0
+	}
+	if len(result.DSNames) != dsCount {
+		return nil, resp, fmt.Errorf("FETCHBIN returned %d data sources, expected %d", len(result.DSNames), dsCount)
+	}
+
+	result.Timestamps = make([]int64, rows)
+	result.Values = make([][]float64, rows)
+	for row := 0; row < rows; row++ {
+		result.Timestamps[row] = result.Start + int64(row+1)*result.Step
+		result.Values[row] = values[row*dsCount : (row+1)*dsCount]
+	}
+
+	return result, resp, nil
+}
+
+func parseBinaryHeader(header string) (int, binary.ByteOrder, error) {
+	fields := strings.Fields(header)
+	if len(fields) != 4 {
+		return 0, nil, fmt.Errorf("expected 4 fields, got %d", len(fields))
+	}
+
+	records, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, nil, err
+	}
+	if fields[2] != "8" {
+		return 0, nil, fmt.Errorf("unsupported record size %s", fields[2])
+	}
+
+	switch fields[3] {
+	case "LITTLE":
+		return records, binary.LittleEndian, nil
+	case "BIG":
+		return records, binary.BigEndian, nil
+	}
+	return 0, nil, fmt.Errorf("unsupported byte order %s", fields[3])
+}
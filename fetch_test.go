@@ -0,0 +1,247 @@
+package rrdcached
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testFetchResponse = "10 Success\n" +
+	"FlushVersion: 1\n" +
+	"Start: 1438354500\n" +
+	"Step: 300\n" +
+	"End: 1438355400\n" +
+	"DSCount: 2\n" +
+	"DSName: test1 test2\n" +
+	"1438354800: 1.0000000000e+01 2.0000000000e+01\n" +
+	"1438355100: nan -nan\n" +
+	"1438355400: 9.0000000000e+01 8.0000000000e+01\n"
+
+func TestFetch(t *testing.T) {
+	expected, fakeDriver := prepTestData(
+		"FETCH foo.rrd AVERAGE 1438354500 1438355400\n",
+		testFetchResponse,
+	)
+
+	result, err := fakeDriver.Fetch("foo.rrd", "AVERAGE", 1438354500, 1438355400, -1)
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, fakeDriver.Rrdio)
+	assert.Equal(t, uint64(1), result.FlushVersion)
+	assert.Equal(t, int64(1438354500), result.Start)
+	assert.Equal(t, int64(1438355400), result.End)
+	assert.Equal(t, int64(300), result.Step)
+	assert.Equal(t, []string{"test1", "test2"}, result.DSNames)
+	assert.Equal(t, []int64{1438354800, 1438355100, 1438355400}, result.Timestamps)
+	assert.Equal(t, []float64{10, 20}, result.Values[0])
+	assert.True(t, math.IsNaN(result.Values[1][0]))
+	assert.True(t, math.IsNaN(result.Values[1][1]))
+	assert.Equal(t, []float64{90, 80}, result.Values[2])
+}
+
+func TestFetchWithoutRange(t *testing.T) {
+	expected, fakeDriver := prepTestData(
+		"FETCH foo.rrd MAX\n",
+		testFetchResponse,
+	)
+
+	result, err := fakeDriver.Fetch("foo.rrd", "MAX", -1, -1, 300)
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, fakeDriver.Rrdio)
+	assert.Len(t, result.Values, 3)
+}
+
+func TestFetchStepMismatch(t *testing.T) {
+	_, fakeDriver := prepTestData(
+		"FETCH foo.rrd AVERAGE\n",
+		testFetchResponse,
+	)
+
+	result, err := fakeDriver.Fetch("foo.rrd", "AVERAGE", -1, -1, 60)
+
+	assert.Error(t, err)
+	assert.Equal(t, int64(300), result.Step)
+}
+
+func TestFetchEndWithoutStart(t *testing.T) {
+	_, fakeDriver := prepTestData("", testFetchResponse)
+
+	_, err := fakeDriver.Fetch("foo.rrd", "AVERAGE", -1, 1438355400, -1)
+
+	assert.Error(t, err)
+}
+
+func TestFetchWithoutExistingRRD(t *testing.T) {
+	_, fakeDriver := prepTestData(
+		"FETCH foo.rrd AVERAGE\n",
+		"-1 No such file: /tmp/foo.rrd",
+	)
+
+	_, err := fakeDriver.Fetch("foo.rrd", "AVERAGE", -1, -1, -1)
+
+	assert.IsType(t, &FileDoesNotExistError{}, err)
+}
+
+func TestFetchMalformed(t *testing.T) {
+	_, fakeDriver := prepTestData(
+		"FETCH foo.rrd AVERAGE\n",
+		"3 Success\nDSCount: 2\nDSName: test1 test2\n1438354800: 1.0e+01\n",
+	)
+
+	_, err := fakeDriver.Fetch("foo.rrd", "AVERAGE", -1, -1, -1)
+
+	assert.Error(t, err)
+}
+
+type fakeStreamTransport struct {
+	*fakeDataTransport
+	stream *bufio.Reader
+}
+
+func (rrdio *fakeStreamTransport) ReadLine(r io.Reader) (string, error) {
+	return rrdio.stream.ReadString('\n')
+}
+
+func (rrdio *fakeStreamTransport) ReadFull(r io.Reader, buf []byte) error {
+	_, err := io.ReadFull(rrdio.stream, buf)
+	return err
+}
+
+func prepStreamTestData(fakeResponse []byte) (transport *fakeStreamTransport, fakeDriver *Rrdcached) {
+	transport = &fakeStreamTransport{
+		fakeDataTransport: &fakeDataTransport{},
+		stream:            bufio.NewReader(bytes.NewReader(fakeResponse)),
+	}
+	return transport, &Rrdcached{Rrdio: transport}
+}
+
+func binaryFetchResponse(order binary.ByteOrder, orderName string, start int64, step int64, names []string, columns [][]float64) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d Success\n", 5+len(names))
+	fmt.Fprintf(&buf, "FlushVersion: 1\nStart: %d\nStep: %d\nEnd: %d\nDSCount: %d\n", start, step, start+step*int64(len(columns[0])), len(names))
+	for i, name := range names {
+		fmt.Fprintf(&buf, "DSName-%s: BinaryData %d 8 %s\n", name, len(columns[i]), orderName)
+		for _, value := range columns[i] {
+			binary.Write(&buf, order, value)
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+func TestFetchBinary(t *testing.T) {
+	transport, fakeDriver := prepStreamTestData(binaryFetchResponse(
+		binary.LittleEndian, "LITTLE", 1438354500, 300,
+		[]string{"test1", "test2"},
+		[][]float64{{10, math.NaN(), 90}, {20, math.NaN(), 80}},
+	))
+
+	result, err := fakeDriver.FetchBinary("foo.rrd", "AVERAGE", 1438354500, 1438355400, 300)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "FETCHBIN foo.rrd AVERAGE 1438354500 1438355400\n", transport.written)
+	assert.Equal(t, int64(1438354500), result.Start)
+	assert.Equal(t, int64(1438355400), result.End)
+	assert.Equal(t, []string{"test1", "test2"}, result.DSNames)
+	assert.Equal(t, []int64{1438354800, 1438355100, 1438355400}, result.Timestamps)
+	assert.Equal(t, []float64{10, 20}, result.Values[0])
+	assert.True(t, math.IsNaN(result.Values[1][0]))
+	assert.True(t, math.IsNaN(result.Values[1][1]))
+	assert.Equal(t, []float64{90, 80}, result.Values[2])
+}
+
+func TestFetchBinaryBigEndian(t *testing.T) {
+	_, fakeDriver := prepStreamTestData(binaryFetchResponse(
+		binary.BigEndian, "BIG", 1438354500, 300,
+		[]string{"test1"},
+		[][]float64{{1.5, 2.5}},
+	))
+
+	result, err := fakeDriver.FetchBinary("foo.rrd", "AVERAGE", -1, -1, -1)
+
+	assert.NoError(t, err)
+	assert.Equal(t, [][]float64{{1.5}, {2.5}}, result.Values)
+}
+
+func TestFetchBinaryNewlineInData(t *testing.T) {
+	// 0x0a bytes inside a block must not be mistaken for line endings.
+	value := math.Float64frombits(0x0a0a0a0a0a0a0a0a)
+	_, fakeDriver := prepStreamTestData(binaryFetchResponse(
+		binary.LittleEndian, "LITTLE", 1438354500, 300,
+		[]string{"test1", "test2"},
+		[][]float64{{value}, {value}},
+	))
+
+	result, err := fakeDriver.FetchBinary("foo.rrd", "AVERAGE", -1, -1, -1)
+
+	assert.NoError(t, err)
+	assert.Equal(t, [][]float64{{value, value}}, result.Values)
+}
+
+func TestFetchBinaryWithoutExistingRRD(t *testing.T) {
+	_, fakeDriver := prepStreamTestData([]byte("-1 No such file: /tmp/foo.rrd\n"))
+
+	_, err := fakeDriver.FetchBinary("foo.rrd", "AVERAGE", -1, -1, -1)
+
+	assert.IsType(t, &FileDoesNotExistError{}, err)
+}
+
+func TestFetchBinaryUnsupportedTransport(t *testing.T) {
+	_, fakeDriver := prepTestData("", "")
+
+	_, err := fakeDriver.FetchBinary("foo.rrd", "AVERAGE", -1, -1, -1)
+
+	assert.Error(t, err)
+}
+
+// ------------------------------------------
+// Benchmarks
+
+const benchmarkFetchRows = 50000
+
+func benchmarkFetchColumns() [][]float64 {
+	columns := make([][]float64, 2)
+	for i := range columns {
+		columns[i] = make([]float64, benchmarkFetchRows)
+		for row := range columns[i] {
+			columns[i][row] = float64(row * (i + 1))
+		}
+	}
+	return columns
+}
+
+func BenchmarkFetch(b *testing.B) {
+	columns := benchmarkFetchColumns()
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "%d Success\nFlushVersion: 1\nStart: 0\nStep: 60\nEnd: %d\nDSCount: 2\nDSName: test1 test2\n", benchmarkFetchRows+6, 60*benchmarkFetchRows)
+	for row := 0; row < benchmarkFetchRows; row++ {
+		fmt.Fprintf(&buf, "%d: %0.10e %0.10e\n", 60*(row+1), columns[0][row], columns[1][row])
+	}
+	response := buf.String()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, fakeDriver := prepTestData("", response)
+		fakeDriver.Fetch("foo.rrd", "AVERAGE", -1, -1, -1)
+	}
+}
+
+func BenchmarkFetchBinary(b *testing.B) {
+	response := binaryFetchResponse(binary.LittleEndian, "LITTLE", 0, 60, []string{"test1", "test2"}, benchmarkFetchColumns())
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, fakeDriver := prepStreamTestData(response)
+		fakeDriver.FetchBinary("foo.rrd", "AVERAGE", -1, -1, -1)
+	}
+}
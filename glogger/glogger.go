@@ -0,0 +1,49 @@
+// Package glogger logs rrdcached clients through glog, for programs that already use it:
+//
+//	rrdcached.New(address, rrdcached.WithLogger(glogger.New(10)))
+package glogger
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dreadpirateshawn/rrdcached"
+	"github.com/golang/glog"
+)
+
+// Logger writes debug events at verbosity Verbosity, and the others at their own severity.
+type Logger struct {
+	Verbosity glog.Level
+}
+
+func New(verbosity glog.Level) *Logger {
+	return &Logger{Verbosity: verbosity}
+}
+
+func (l *Logger) Log(level rrdcached.LogLevel, msg string, keyvals ...interface{}) {
+	line := format(msg, keyvals)
+	switch level {
+	case rrdcached.LevelDebug:
+		glog.V(l.Verbosity).Info(line)
+	case rrdcached.LevelInfo:
+		glog.Info(line)
+	case rrdcached.LevelWarn:
+		glog.Warning(line)
+	default:
+		glog.Error(line)
+	}
+}
+
+// format renders the fields as key=value pairs after the message.
+func format(msg string, keyvals []interface{}) string {
+	var line strings.Builder
+	line.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		if i+1 < len(keyvals) {
+			fmt.Fprintf(&line, " %v=%v", keyvals[i], keyvals[i+1])
+		} else {
+			fmt.Fprintf(&line, " %v", keyvals[i])
+		}
+	}
+	return line.String()
+}
@@ -0,0 +1,19 @@
+package glogger
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dreadpirateshawn/rrdcached"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ rrdcached.Logger = (*Logger)(nil)
+
+func TestFormat(t *testing.T) {
+	assert.Equal(t, "reply command=FLUSH filename=foo.rrd status=0 latency=1.5ms",
+		format("reply", []interface{}{"command", "FLUSH", "filename", "foo.rrd", "status", 0, "latency", 1500 * time.Microsecond}))
+	assert.Equal(t, "command failed error=No such file", format("command failed", []interface{}{"error", errors.New("No such file")}))
+	assert.Equal(t, "odd dangling", format("odd", []interface{}{"dangling"}))
+}
@@ -0,0 +1,209 @@
+package rrdcached
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GuardPolicy decides what a TimestampGuard does with update values whose timestamps are
+// not later than the last one sent for their file, which the daemon would refuse.
+type GuardPolicy int
+
+const (
+	// GuardReject fails the whole update with a NonMonotonicError, sending none of its values.
+	GuardReject GuardPolicy = iota
+	// GuardDrop leaves out the values that are not later than the ones before them.
+	GuardDrop
+	// GuardCoalesce is GuardDrop, except that of several values with the same timestamp
+	// in one update, the last is sent rather than the first.
+	GuardCoalesce
+)
+
+func (p GuardPolicy) String() string {
+	switch p {
+	case GuardReject:
+		return "reject"
+	case GuardDrop:
+		return "drop"
+	case GuardCoalesce:
+		return "coalesce"
+	}
+	return "GuardPolicy(" + strconv.Itoa(int(p)) + ")"
+}
+
+// GuardReport describes the values of one update that a TimestampGuard did not send.
+// Last is the latest timestamp of Filename when the first of Values was left out.
+type GuardReport struct {
+	Filename string
+	Policy   GuardPolicy
+	Last     float64
+	Values   []string
+}
+
+// NonMonotonicError is returned for updates rejected under GuardReject.
+// It matches ErrTimestampTooOld, as the daemon's own complaint would.
+type NonMonotonicError struct {
+	GuardReport
+}
+
+func (f *NonMonotonicError) Error() string {
+	return fmt.Sprintf("update of %s with %s is not after the last update at %s",
+		f.Filename, f.Values[0], strconv.FormatFloat(f.Last, 'f', -1, 64))
+}
+
+func (f *NonMonotonicError) Is(target error) bool {
+	return target == ErrIllegalUpdate || target == ErrTimestampTooOld
+}
+
+// guardedResponse is returned for updates that the guard left no values of.
+var guardedResponse = Response{Message: "all values left out by the timestamp guard", Raw: "0 all values left out by the timestamp guard"}
+
+// TimestampGuard tracks the last timestamp sent to each file, so that updates which are
+// not later than it can be dealt with before they reach the daemon:
+//
+//	guard := NewTimestampGuard(GuardDrop, nil)
+//	New(address, WithTimestampGuard(guard))
+//
+// The first update of a file is checked against its LAST, if the daemon supports it.
+// When an update fails anyway, whether refused by the daemon or lost with the connection,
+// the file is looked up again on its next update.
+// One guard may be shared by several clients, e.g. those of a pool, but updates of a file
+// from several goroutines may still reach the daemon in a different order than they were checked.
+type TimestampGuard struct {
+	policy GuardPolicy
+	report func(GuardReport)
+
+	mu   sync.Mutex
+	last map[string]float64
+}
+
+// NewTimestampGuard returns a guard applying policy. report, if not nil, is called
+// for every update that had values left out or was rejected.
+func NewTimestampGuard(policy GuardPolicy, report func(GuardReport)) *TimestampGuard {
+	return &TimestampGuard{policy: policy, report: report, last: map[string]float64{}}
+}
+
+func (g *TimestampGuard) known(filename string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	_, ok := g.last[filename]
+	return ok
+}
+
+func (g *TimestampGuard) forget(filename string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.last, filename)
+}
+
+// filter applies the policy to values, comparing them with the last timestamp recorded for
+// filename or, if there is none, with seed. The timestamps kept are recorded.
+func (g *TimestampGuard) filter(filename string, seed float64, seeded bool, values []string) ([]string, GuardReport, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	last, ok := g.last[filename]
+	if !ok {
+		last, ok = seed, seeded
+	}
+	report := GuardReport{Filename: filename, Policy: g.policy}
+
+	kept := make([]string, 0, len(values))
+	keptLast := -1
+	for _, value := range values {
+		timestamp, parsed := updateTimestamp(value)
+		if !parsed {
+			// Left for the daemon to complain about.
+			kept = append(kept, value)
+			continue
+		}
+		if !ok || timestamp > last {
+			kept = append(kept, value)
+			keptLast = len(kept) - 1
+			last, ok = timestamp, true
+			continue
+		}
+
+		if len(report.Values) == 0 {
+			report.Last = last
+		}
+		switch {
+		case g.policy == GuardReject:
+			report.Values = []string{value}
+			return nil, report, &NonMonotonicError{report}
+		case g.policy == GuardCoalesce && keptLast >= 0 && timestamp == last:
+			report.Values = append(report.Values, kept[keptLast])
+			kept[keptLast] = value
+		default:
+			report.Values = append(report.Values, value)
+		}
+	}
+
+	if ok {
+		g.last[filename] = last
+	}
+	return kept, report, nil
+}
+
+// updateTimestamp parses the timestamp of a "<timestamp>:<value>[:<value>...]" update value.
+func updateTimestamp(value string) (float64, bool) {
+	stamp, _, found := strings.Cut(value, ":")
+	if !found {
+		return 0, false
+	}
+	if stamp == "N" {
+		return float64(time.Now().UnixNano()) / float64(time.Second), true
+	}
+	timestamp, err := strconv.ParseFloat(stamp, 64)
+	return timestamp, err == nil
+}
+
+// guardUpdate returns the values of an update to send, and false if there are none left.
+func (r *Rrdcached) guardUpdate(ctx context.Context, filename string, values []string) ([]string, bool, error) {
+	if r.guard == nil {
+		return values, true, nil
+	}
+
+	var seed float64
+	var seeded bool
+	if !r.guard.known(filename) {
+		resp, err := r.LastContext(ctx, filename)
+		switch {
+		case err == nil:
+			seed, err = strconv.ParseFloat(resp.Message, 64)
+			seeded = err == nil
+		case errors.Is(err, ErrUnknownCommand), errors.Is(err, ErrNoSuchFile):
+			// Nothing to check the first update against; a missing file is left for UPDATE to report.
+		default:
+			return nil, false, err
+		}
+	}
+
+	kept, report, err := r.guard.filter(filename, seed, seeded, values)
+	if len(report.Values) > 0 {
+		r.log(LevelWarn, "timestamp guard", "filename", filename, "policy", report.Policy, "values", strings.Join(report.Values, " "))
+		if r.guard.report != nil {
+			r.guard.report(report)
+		}
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return kept, len(kept) > 0 || len(values) == 0, nil
+}
+
+// guardFailed makes the guard look up filename again unless the daemon accepted its update,
+// since the values the guard recorded may not have been applied. An update that failed with
+// a ConnectionError or TimeoutError may never have reached the daemon, and is likely to be retried.
+func (r *Rrdcached) guardFailed(filename string, err error) {
+	if r.guard != nil && err != nil {
+		r.guard.forget(filename)
+	}
+}
@@ -0,0 +1,262 @@
+package rrdcached
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func prepGuardTestData(policy GuardPolicy, fakeResponses ...string) (*fakeSequenceTransport, *Rrdcached, *[]GuardReport) {
+	reports := &[]GuardReport{}
+	transport, fakeDriver := prepSequenceTestData(fakeResponses...)
+	fakeDriver.guard = NewTimestampGuard(policy, func(report GuardReport) {
+		*reports = append(*reports, report)
+	})
+	return transport, fakeDriver, reports
+}
+
+func TestGuardDrop(t *testing.T) {
+	transport, fakeDriver, reports := prepGuardTestData(GuardDrop,
+		"0 1438354679",
+		"0 errors, enqueued 2 value(s).",
+		"0 errors, enqueued 1 value(s).",
+	)
+
+	_, err := fakeDriver.Update("foo.rrd", "1438354679:1", "1438354680:2", "1438354680:3", "1438354681:4")
+	assert.NoError(t, err)
+	_, err = fakeDriver.Update("foo.rrd", "1438354681:5", "1438354682:6")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"LAST foo.rrd\n",
+		"UPDATE foo.rrd 1438354680:2 1438354681:4\n",
+		"UPDATE foo.rrd 1438354682:6\n",
+	}, transport.written)
+	assert.Equal(t, []GuardReport{
+		{Filename: "foo.rrd", Policy: GuardDrop, Last: 1438354679, Values: []string{"1438354679:1", "1438354680:3"}},
+		{Filename: "foo.rrd", Policy: GuardDrop, Last: 1438354681, Values: []string{"1438354681:5"}},
+	}, *reports)
+}
+
+func TestGuardCoalesce(t *testing.T) {
+	transport, fakeDriver, reports := prepGuardTestData(GuardCoalesce,
+		"0 1438354679",
+		"0 errors, enqueued 2 value(s).",
+	)
+
+	_, err := fakeDriver.Update("foo.rrd", "1438354679:1", "1438354680:2", "1438354680:3", "1438354681:4")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"LAST foo.rrd\n",
+		"UPDATE foo.rrd 1438354680:3 1438354681:4\n",
+	}, transport.written)
+	assert.Equal(t, []string{"1438354679:1", "1438354680:2"}, (*reports)[0].Values)
+}
+
+func TestGuardReject(t *testing.T) {
+	transport, fakeDriver, reports := prepGuardTestData(GuardReject,
+		"0 1438354679",
+	)
+
+	resp, err := fakeDriver.Update("foo.rrd", "1438354680:1", "1438354679.5:2")
+
+	assert.Nil(t, resp)
+	assert.EqualError(t, err, "update of foo.rrd with 1438354679.5:2 is not after the last update at 1438354680")
+	assert.True(t, errors.Is(err, ErrTimestampTooOld))
+	assert.Equal(t, []string{"LAST foo.rrd\n"}, transport.written)
+	assert.Len(t, *reports, 1)
+}
+
+func TestGuardAllLeftOut(t *testing.T) {
+	transport, fakeDriver, _ := prepGuardTestData(GuardDrop,
+		"0 1438354679",
+	)
+
+	resp, err := fakeDriver.Update("foo.rrd", "1438354678:1", "1438354679:2")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, resp.Status)
+	assert.Equal(t, []string{"LAST foo.rrd\n"}, transport.written)
+}
+
+func TestGuardWithoutLast(t *testing.T) {
+	transport, fakeDriver, _ := prepGuardTestData(GuardDrop,
+		"-1 Unknown command: LAST",
+		"0 errors, enqueued 1 value(s).",
+		"0 errors, enqueued 1 value(s).",
+	)
+
+	_, err := fakeDriver.Update("foo.rrd", "1438354680:1")
+	assert.NoError(t, err)
+	_, err = fakeDriver.Update("foo.rrd", "1438354680:2", "1438354681:3")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"LAST foo.rrd\n",
+		"UPDATE foo.rrd 1438354680:1\n",
+		"UPDATE foo.rrd 1438354681:3\n",
+	}, transport.written)
+}
+
+func TestGuardRefused(t *testing.T) {
+	transport, fakeDriver, _ := prepGuardTestData(GuardDrop,
+		"0 1438354679",
+		"-1 illegal attempt to update using time 1438354680 when last update time is 1438354690 (minimum one second step)",
+		"0 1438354690",
+		"0 errors, enqueued 1 value(s).",
+	)
+
+	_, err := fakeDriver.Update("foo.rrd", "1438354680:1")
+	assert.True(t, errors.Is(err, ErrTimestampTooOld))
+	_, err = fakeDriver.Update("foo.rrd", "1438354685:2", "1438354691:3")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"LAST foo.rrd\n",
+		"UPDATE foo.rrd 1438354680:1\n",
+		"LAST foo.rrd\n",
+		"UPDATE foo.rrd 1438354691:3\n",
+	}, transport.written)
+}
+
+func TestGuardBatch(t *testing.T) {
+	transport, fakeDriver, _ := prepGuardTestData(GuardDrop,
+		"0 1438354679",
+		"-1 No such file: /tmp/bar.rrd",
+		"0 Go ahead.  End with dot '.' on its own line.",
+		"0 errors",
+	)
+
+	_, err := fakeDriver.Batch().
+		Update("foo.rrd", "1438354679:1", "1438354680:2").
+		Update("bar.rrd", "1438354679:1").
+		Update("foo.rrd", "1438354680:3").
+		Flush("foo.rrd").
+		Exec()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"LAST foo.rrd\n",
+		"LAST bar.rrd\n",
+		"BATCH\n",
+		"UPDATE foo.rrd 1438354680:2\nUPDATE bar.rrd 1438354679:1\nFLUSH foo.rrd\n.\n",
+	}, transport.written)
+}
+
+func TestGuardBatchReject(t *testing.T) {
+	transport, fakeDriver, _ := prepGuardTestData(GuardReject,
+		"0 1438354679",
+	)
+
+	_, err := fakeDriver.Batch().
+		Update("foo.rrd", "1438354680:1").
+		Update("foo.rrd", "1438354680:2").
+		Exec()
+
+	assert.IsType(t, &NonMonotonicError{}, err)
+	assert.Equal(t, []string{"LAST foo.rrd\n"}, transport.written)
+	assert.False(t, fakeDriver.guard.known("foo.rrd"))
+}
+
+func TestGuardBatchErrorLine(t *testing.T) {
+	_, fakeDriver, _ := prepGuardTestData(GuardDrop,
+		"0 1438354679",
+		"-1 No such file: /tmp/bar.rrd",
+		"0 Go ahead.  End with dot '.' on its own line.",
+		"1 errors\n1 No such file: /tmp/bar.rrd",
+	)
+
+	_, err := fakeDriver.Batch().
+		Update("foo.rrd", "1438354679:1").
+		Update("bar.rrd", "1438354679:1").
+		Exec()
+
+	// The update of foo.rrd was left out, so the first command sent is the second one built.
+	if assert.IsType(t, &BatchError{}, err) {
+		assert.Equal(t, []*BatchCommandError{
+			{Line: 2, Command: "UPDATE bar.rrd 1438354679:1", Message: "No such file: /tmp/bar.rrd"},
+		}, err.(*BatchError).Errors)
+	}
+}
+
+func TestGuardBatchConnectionLost(t *testing.T) {
+	_, fakeDriver, _ := prepGuardTestData(GuardDrop,
+		"0 1438354679",
+		"0 Go ahead.  End with dot '.' on its own line.",
+	)
+
+	_, err := fakeDriver.Batch().Update("foo.rrd", "1438354680:1").Exec()
+
+	assert.Error(t, err)
+	assert.False(t, fakeDriver.guard.known("foo.rrd"))
+}
+
+// answerLast answers HELP, LAST with 1438354679 and UPDATE with success, and sends the updates it
+// receives on updates. The first connection hangs up on its first update if drop is set,
+// and otherwise never answers it.
+func answerLast(drop bool, updates chan<- string) func(n int, conn net.Conn, in *bufio.Reader) {
+	return func(n int, conn net.Conn, in *bufio.Reader) {
+		for {
+			line, err := in.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if line == "HELP\n" {
+				conn.Write([]byte("2 Command overview\nUPDATE <filename> <values> [<values> ...]\nLAST <filename>\n"))
+				continue
+			}
+			if strings.HasPrefix(line, "LAST ") {
+				conn.Write([]byte("0 1438354679\n"))
+				continue
+			}
+			if n == 0 && drop {
+				return
+			}
+			if n == 0 {
+				continue
+			}
+			updates <- strings.TrimSpace(line)
+			conn.Write([]byte("0 errors, enqueued 1 value(s).\n"))
+		}
+	}
+}
+
+func TestGuardRetryAfterDroppedConnection(t *testing.T) {
+	updates := make(chan string, 1)
+	guard := NewTimestampGuard(GuardDrop, nil)
+	driver, err := New(startFakeDaemon(t, answerLast(true, updates)), WithTimestampGuard(guard), WithReconnect(testReconnectPolicy))
+	assert.NoError(t, err)
+
+	_, err = driver.Update("foo.rrd", "1438354680:1")
+	assert.IsType(t, &ConnectionError{}, err)
+
+	// The update may never have reached the daemon, so retrying it sends it again.
+	resp, err := driver.Update("foo.rrd", "1438354680:1")
+	assert.NoError(t, err)
+	assert.Equal(t, "errors, enqueued 1 value(s).", resp.Message)
+	assert.Equal(t, "UPDATE foo.rrd 1438354680:1", <-updates)
+}
+
+func TestGuardRetryAfterDeadline(t *testing.T) {
+	updates := make(chan string, 1)
+	guard := NewTimestampGuard(GuardReject, nil)
+	driver, err := New(startFakeDaemon(t, answerLast(false, updates)), WithTimestampGuard(guard), WithReconnect(testReconnectPolicy))
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = driver.UpdateContext(ctx, "foo.rrd", "1438354680:1")
+	assert.IsType(t, &TimeoutError{}, err)
+
+	resp, err := driver.Update("foo.rrd", "1438354680:1")
+	assert.NoError(t, err)
+	assert.Equal(t, "errors, enqueued 1 value(s).", resp.Message)
+	assert.Equal(t, "UPDATE foo.rrd 1438354680:1", <-updates)
+}
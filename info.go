@@ -0,0 +1,246 @@
+package rrdcached
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RRDInfo is the header of an RRD as reported by INFO.
+// Raw keeps every key the daemon sent, including those without a typed field.
+type RRDInfo struct {
+	Filename   string
+	Version    string
+	HeaderSize uint64
+	Step       uint64
+	LastUpdate int64
+	DS         []RRDInfoDS
+	RRA        []RRDInfoRRA
+	Raw        map[string]string
+}
+
+type RRDInfoDS struct {
+	Name             string
+	Index            int
+	Type             string
+	MinimalHeartbeat uint64
+	Min              float64
+	Max              float64
+	LastDS           string
+	Value            float64
+	UnknownSec       uint64
+}
+
+type RRDInfoRRA struct {
+	CF        string
+	Rows      uint64
+	CurRow    uint64
+	PdpPerRow uint64
+	XFF       float64
+}
+
+func (r *Rrdcached) Info(filename string) (*RRDInfo, error) {
+	return r.InfoContext(context.Background(), filename)
+}
+
+func (r *Rrdcached) InfoContext(ctx context.Context, filename string) (*RRDInfo, error) {
+	resp, err := r.exec(ctx, "INFO", filename)
+	if err != nil {
+		return nil, err
+	}
+	return parseInfo(resp.Raw)
+}
+
+// parseInfo reads the "<key> <type> <value>" lines of an INFO reply.
+// The type is rrd_info's value kind, which the key already implies, so it is not checked.
+func parseInfo(data string) (*RRDInfo, error) {
+	info := &RRDInfo{Raw: map[string]string{}}
+	dsByName := map[string]*RRDInfoDS{}
+	rraByIndex := map[int]*RRDInfoRRA{}
+
+	for _, line := range strings.Split(data, "\n")[1:] {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		field := strings.SplitN(line, " ", 3)
+		if len(field) != 3 {
+			return nil, fmt.Errorf("INFO returned malformed line %q", line)
+		}
+		key, value := field[0], field[2]
+		info.Raw[key] = value
+
+		var err error
+		switch {
+		case key == "filename":
+			info.Filename = value
+		case key == "rrd_version":
+			info.Version = value
+		case key == "header_size":
+			info.HeaderSize, err = strconv.ParseUint(value, 10, 64)
+		case key == "step":
+			info.Step, err = strconv.ParseUint(value, 10, 64)
+		case key == "last_update":
+			info.LastUpdate, err = strconv.ParseInt(value, 10, 64)
+		case strings.HasPrefix(key, "ds["):
+			err = parseInfoDS(dsByName, key, value)
+		case strings.HasPrefix(key, "rra["):
+			err = parseInfoRRA(rraByIndex, key, value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("INFO returned malformed line %q: %v", line, err)
+		}
+	}
+
+	for _, ds := range dsByName {
+		info.DS = append(info.DS, *ds)
+	}
+	sort.Slice(info.DS, func(i, j int) bool { return info.DS[i].Index < info.DS[j].Index })
+
+	info.RRA = make([]RRDInfoRRA, len(rraByIndex))
+	for index, rra := range rraByIndex {
+		if index < 0 || index >= len(info.RRA) {
+			return nil, fmt.Errorf("INFO returned non-contiguous RRA index %d", index)
+		}
+		info.RRA[index] = *rra
+	}
+
+	return info, nil
+}
+
+// splitInfoKey splits "ds[test1].min" into "test1" and "min".
+func splitInfoKey(key string) (string, string, error) {
+	start := strings.Index(key, "[")
+	end := strings.Index(key, "].")
+	if start < 0 || end < start {
+		return "", "", fmt.Errorf("unrecognized key %q", key)
+	}
+	return key[start+1 : end], key[end+2:], nil
+}
+
+func parseInfoDS(dsByName map[string]*RRDInfoDS, key string, value string) error {
+	name, attr, err := splitInfoKey(key)
+	if err != nil {
+		return err
+	}
+	ds, ok := dsByName[name]
+	if !ok {
+		ds = &RRDInfoDS{Name: name, Min: math.NaN(), Max: math.NaN(), Value: math.NaN()}
+		dsByName[name] = ds
+	}
+
+	switch attr {
+	case "index":
+		ds.Index, err = strconv.Atoi(value)
+	case "type":
+		ds.Type = value
+	case "minimal_heartbeat":
+		ds.MinimalHeartbeat, err = strconv.ParseUint(value, 10, 64)
+	case "min":
+		ds.Min, err = parseValue(value)
+	case "max":
+		ds.Max, err = parseValue(value)
+	case "last_ds":
+		ds.LastDS = value
+	case "value":
+		ds.Value, err = parseValue(value)
+	case "unknown_sec":
+		ds.UnknownSec, err = strconv.ParseUint(value, 10, 64)
+	}
+	return err
+}
+
+func parseInfoRRA(rraByIndex map[int]*RRDInfoRRA, key string, value string) error {
+	indexStr, attr, err := splitInfoKey(key)
+	if err != nil {
+		return err
+	}
+	index, err := strconv.Atoi(indexStr)
+	if err != nil {
+		return err
+	}
+	rra, ok := rraByIndex[index]
+	if !ok {
+		rra = &RRDInfoRRA{XFF: math.NaN()}
+		rraByIndex[index] = rra
+	}
+
+	switch attr {
+	case "cf":
+		rra.CF = value
+	case "rows":
+		rra.Rows, err = strconv.ParseUint(value, 10, 64)
+	case "cur_row":
+		rra.CurRow, err = strconv.ParseUint(value, 10, 64)
+	case "pdp_per_row":
+		rra.PdpPerRow, err = strconv.ParseUint(value, 10, 64)
+	case "xff":
+		rra.XFF, err = parseValue(value)
+	}
+	return err
+}
+
+// MatchesSchema compares the header with the definitions that would be passed to Create,
+// and describes the first difference found. A negative step is not compared.
+func (info *RRDInfo) MatchesSchema(step int64, ds []string, rra []string) error {
+	if step >= 0 && uint64(step) != info.Step {
+		return fmt.Errorf("step is %d, expected %d", info.Step, step)
+	}
+
+	if len(ds) != len(info.DS) {
+		return fmt.Errorf("%d data sources, expected %d", len(info.DS), len(ds))
+	}
+	for i, def := range ds {
+		// DS:name:type:heartbeat:min:max
+		parts := strings.Split(def, ":")
+		actual := info.DS[i]
+		if len(parts) < 3 || parts[0] != "DS" {
+			return fmt.Errorf("unrecognized DS definition %q", def)
+		}
+		if parts[1] != actual.Name || parts[2] != actual.Type {
+			return fmt.Errorf("data source %d is %s:%s, expected %s:%s", i, actual.Name, actual.Type, parts[1], parts[2])
+		}
+		if len(parts) == 6 {
+			if !matchesNumber(parts[3], float64(actual.MinimalHeartbeat)) || !matchesNumber(parts[4], actual.Min) || !matchesNumber(parts[5], actual.Max) {
+				return fmt.Errorf("data source %s has heartbeat %d, min %v, max %v, expected %s", actual.Name, actual.MinimalHeartbeat, actual.Min, actual.Max, def)
+			}
+		}
+	}
+
+	if len(rra) != len(info.RRA) {
+		return fmt.Errorf("%d archives, expected %d", len(info.RRA), len(rra))
+	}
+	for i, def := range rra {
+		// RRA:CF:xff:steps:rows
+		parts := strings.Split(def, ":")
+		actual := info.RRA[i]
+		if len(parts) < 2 || parts[0] != "RRA" {
+			return fmt.Errorf("unrecognized RRA definition %q", def)
+		}
+		if parts[1] != actual.CF {
+			return fmt.Errorf("archive %d is %s, expected %s", i, actual.CF, parts[1])
+		}
+		if len(parts) == 5 {
+			if !matchesNumber(parts[2], actual.XFF) || !matchesNumber(parts[3], float64(actual.PdpPerRow)) || !matchesNumber(parts[4], float64(actual.Rows)) {
+				return fmt.Errorf("archive %d has xff %v, steps %d, rows %d, expected %s", i, actual.XFF, actual.PdpPerRow, actual.Rows, def)
+			}
+		}
+	}
+
+	return nil
+}
+
+// matchesNumber treats "U" in a definition as matching an unknown (NaN) value.
+func matchesNumber(def string, actual float64) bool {
+	expected, err := parseValue(def)
+	if err != nil {
+		return false
+	}
+	if math.IsNaN(expected) || math.IsNaN(actual) {
+		return math.IsNaN(expected) && math.IsNaN(actual)
+	}
+	return expected == actual
+}
@@ -0,0 +1,97 @@
+package rrdcached
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testInfoResponse = "27 Info for /tmp/foo.rrd follows\n" +
+	"filename 2 /tmp/foo.rrd\n" +
+	"rrd_version 2 0003\n" +
+	"step 1 300\n" +
+	"last_update 1 1438354680\n" +
+	"header_size 1 2872\n" +
+	"ds[test1].index 1 0\n" +
+	"ds[test1].type 2 GAUGE\n" +
+	"ds[test1].minimal_heartbeat 1 600\n" +
+	"ds[test1].min 0 0.0000000000e+00\n" +
+	"ds[test1].max 0 1.0000000000e+02\n" +
+	"ds[test1].last_ds 2 90\n" +
+	"ds[test1].value 0 2.7000000000e+03\n" +
+	"ds[test1].unknown_sec 1 0\n" +
+	"ds[test2].index 1 1\n" +
+	"ds[test2].type 2 GAUGE\n" +
+	"ds[test2].minimal_heartbeat 1 600\n" +
+	"ds[test2].min 0 0.0000000000e+00\n" +
+	"ds[test2].max 0 nan\n" +
+	"ds[test2].last_ds 2 U\n" +
+	"ds[test2].value 0 nan\n" +
+	"ds[test2].unknown_sec 1 30\n" +
+	"rra[0].cf 2 AVERAGE\n" +
+	"rra[0].rows 1 1440\n" +
+	"rra[0].cur_row 1 17\n" +
+	"rra[0].pdp_per_row 1 1\n" +
+	"rra[0].xff 0 5.0000000000e-01\n" +
+	"rra[0].cdp_prep[0].value 0 nan\n"
+
+func TestInfo(t *testing.T) {
+	expected, fakeDriver := prepTestData(
+		"INFO foo.rrd\n",
+		testInfoResponse,
+	)
+
+	info, err := fakeDriver.Info("foo.rrd")
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, fakeDriver.Rrdio)
+	assert.Equal(t, "/tmp/foo.rrd", info.Filename)
+	assert.Equal(t, "0003", info.Version)
+	assert.Equal(t, uint64(300), info.Step)
+	assert.Equal(t, int64(1438354680), info.LastUpdate)
+	assert.Equal(t, uint64(2872), info.HeaderSize)
+	assert.Equal(t, "nan", info.Raw["rra[0].cdp_prep[0].value"])
+
+	if assert.Len(t, info.DS, 2) {
+		assert.Equal(t, RRDInfoDS{
+			Name: "test1", Index: 0, Type: "GAUGE", MinimalHeartbeat: 600,
+			Min: 0, Max: 100, LastDS: "90", Value: 2700, UnknownSec: 0,
+		}, info.DS[0])
+		assert.Equal(t, "test2", info.DS[1].Name)
+		assert.True(t, math.IsNaN(info.DS[1].Max))
+		assert.True(t, math.IsNaN(info.DS[1].Value))
+		assert.Equal(t, uint64(30), info.DS[1].UnknownSec)
+	}
+	assert.Equal(t, []RRDInfoRRA{
+		{CF: "AVERAGE", Rows: 1440, CurRow: 17, PdpPerRow: 1, XFF: 0.5},
+	}, info.RRA)
+}
+
+func TestInfoWithoutExistingRRD(t *testing.T) {
+	_, fakeDriver := prepTestData(
+		"INFO foo.rrd\n",
+		"-1 No such file: /tmp/foo.rrd",
+	)
+
+	_, err := fakeDriver.Info("foo.rrd")
+
+	assert.IsType(t, &FileDoesNotExistError{}, err)
+}
+
+func TestInfoMatchesSchema(t *testing.T) {
+	info, err := parseInfo(testInfoResponse)
+	assert.NoError(t, err)
+
+	ds := []string{"DS:test1:GAUGE:600:0:100", "DS:test2:GAUGE:600:0:U"}
+	rra := []string{"RRA:AVERAGE:0.5:1:1440"}
+
+	assert.NoError(t, info.MatchesSchema(300, ds, rra))
+	assert.NoError(t, info.MatchesSchema(-1, ds, rra))
+	assert.Error(t, info.MatchesSchema(60, ds, rra))
+	assert.Error(t, info.MatchesSchema(300, []string{"DS:test1:GAUGE:600:0:100", "DS:test2:COUNTER:600:0:U"}, rra))
+	assert.Error(t, info.MatchesSchema(300, []string{"DS:test1:GAUGE:600:0:100", "DS:test2:GAUGE:600:0:100"}, rra))
+	assert.Error(t, info.MatchesSchema(300, ds[:1], rra))
+	assert.Error(t, info.MatchesSchema(300, ds, []string{"RRA:MAX:0.5:1:1440"}))
+	assert.Error(t, info.MatchesSchema(300, ds, []string{"RRA:AVERAGE:0.5:12:1440"}))
+}
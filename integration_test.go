@@ -1,31 +1,29 @@
 // +build integration
 
-package rrdcached
+package rrdcached_test
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
-	"syscall"
 	"testing"
 	"time"
 
-	"github.com/lestrrat/go-tcptest"
+	. "github.com/dreadpirateshawn/rrdcached"
+	"github.com/dreadpirateshawn/rrdcached/rrdcachedtest"
 )
 
 var (
-	testDir  = flag.String("tmpDir", "/tmp", "temp directory for test rrds (default /tmp)")
 	logLevel = flag.Int("logLevel", 0, "logging threshold")
 )
 
 var (
-	testSocket      = *testDir + "/go-rrdcached-test.sock"
-	testRrdFile     = "foo-subdir/go-rrdcached-test.rrd"
-	testRrdFileFull = *testDir + "/" + testRrdFile
+	testRrdFile = "foo-subdir/go-rrdcached-test.rrd"
 )
 
 var (
@@ -35,11 +33,8 @@ var (
 )
 
 var (
-	cmd         *exec.Cmd
-	driver_port int64
-	driver      *Rrdcached
-	daemon      *tcptest.TCPTest
-	connErr     error
+	daemon *rrdcachedtest.Daemon
+	driver *Rrdcached
 )
 
 // ------------------------------------------
@@ -54,64 +49,19 @@ func log(level int, msg string, values ...interface{}) {
 // ------------------------------------------
 // Setup & Teardown
 
+// testSetup starts a daemon that is stopped when the test finishes.
 func testSetup(t *testing.T) {
-	testCleanup()
-	daemonStart()     // Start rrdcached daemon
-	daemonConnect()   // Connect to rrdcached
+	daemon = rrdcachedtest.StartDaemon(t, rrdcachedtest.DaemonOptions{TCP: true})
+	driver = daemon.Client
 	createFreshRRD(t) // Create fresh RRD
 }
 
 func testCleanup() {
-	os.Remove(testRrdFileFull) // Remove existing RRD file
+	os.Remove(filepath.Join(daemon.BaseDir, testRrdFile)) // Remove existing RRD file
 }
 
 func testTeardown() {
 	driver.Quit() // Not strictly necessary, but it feels nice to call this.
-	daemonStop()  // Stop rrdcached daemon
-}
-
-func daemonStart() {
-	// Note: '-g' flag is crucial ("run in foreground"), otherwise rrdcached will run as a forked child process,
-	// and the parent (cmd) will exit and orphan the rrdcached process, leaving no (sane) way to tear it down.
-	cmd_wrapper := func(port int) {
-		driver_port = int64(port)
-
-		var cmd_args = []string{"-g",
-			"-R",
-			"-p", fmt.Sprintf("%v/go-rrdached-test-%d.pid", *testDir, port),
-			"-B", "-b", *testDir,
-			"-l", testSocket,
-			"-l", fmt.Sprintf("0.0.0.0:%d", port)}
-
-		log(0, "Daemon: rrdcached %v", strings.Join(cmd_args, " "))
-
-		cmd = exec.Command("rrdcached", cmd_args...)
-		cmd.SysProcAttr = &syscall.SysProcAttr{
-			Setpgid: true,
-		}
-		cmd.Run()
-	}
-
-	// daemon is a global, but err is new. Using "daemon, err :=" creates a local shadowed variable "daemon". Thus the tmp var.
-	daemon_tmp, err := tcptest.Start(cmd_wrapper, 30*time.Second)
-	if err != nil {
-		log(0, "Daemon: Failed to start:", err)
-		panic(err)
-	}
-	daemon = daemon_tmp
-}
-
-func daemonStop() {
-	if cmd != nil && cmd.Process != nil {
-		if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
-			log(0, "SIGTERM failed:", err)
-		}
-		daemon.Wait()
-	}
-}
-
-func daemonConnect() {
-	driver, connErr = ConnectToSocket(testSocket)
 }
 
 func createFreshRRD(t *testing.T) {
@@ -143,6 +93,15 @@ func generateTimestamps(values []string) []string {
 // ------------------------------------------
 // Helper Validations
 
+// skipIfUnsupported skips the rest of the test if err shows that the daemon lacks command,
+// as older versions of rrdcached do, so that lack of support doesn't cause test failure.
+func skipIfUnsupported(t *testing.T, err error, command string) {
+	t.Helper()
+	if errors.Is(err, ErrUnknownCommand) {
+		t.Skipf("%s is unsupported on this system.", command)
+	}
+}
+
 func verifyNoError(t *testing.T, err error) {
 	if err != nil {
 		t.Errorf("Error encountered: \"%v\"", err)
@@ -167,23 +126,24 @@ func verifyUpdateResponseForN(t *testing.T, resp *Response, update_values []stri
 	}
 }
 
-func verifyPendingResponseForN(t *testing.T, resp *Response, update_values []string) {
-	if resp.Status < 0 {
-		t.Errorf("Status error %v, message: \"%v\"", resp.Status, resp.Message)
-	} else {
-		expected := strconv.FormatInt(int64(len(update_values)), 10) + " updates pending"
-		if !strings.Contains(resp.Raw, expected) {
-			t.Errorf("Raw message response does not contain \"%v\"", expected)
-		}
-		for i := 0; i < len(update_values); i++ {
-			if !strings.Contains(resp.Raw, update_values[i]) {
-				t.Errorf("Update value \"%v\" not found in pending updates", update_values[i])
+func verifyPendingForN(t *testing.T, pending []string, update_values []string) {
+	if len(pending) != len(update_values) {
+		t.Errorf("Expected %v updates pending, got %v", len(update_values), len(pending))
+	}
+	for i := 0; i < len(update_values); i++ {
+		found := false
+		for _, update := range pending {
+			if strings.Contains(update, update_values[i]) {
+				found = true
 			}
 		}
-		if t.Failed() {
-			t.Logf("Raw pending updates:\n%v", resp.Raw)
+		if !found {
+			t.Errorf("Update value \"%v\" not found in pending updates", update_values[i])
 		}
 	}
+	if t.Failed() {
+		t.Logf("Pending updates:\n%v", strings.Join(pending, "\n"))
+	}
 }
 
 func verifyStatsFresh(t *testing.T, stats_diff map[string]uint64) {
@@ -222,11 +182,11 @@ func TestIntegrationConnect(t *testing.T) {
 	testSetup(t)
 	defer testTeardown()
 
-	test_driver1, connErr1 := ConnectToSocket(testSocket)
+	test_driver1, connErr1 := ConnectToSocket(daemon.Socket)
 	verifyNoError(t, connErr1)
 	test_driver1.Quit()
 
-	test_driver2, connErr2 := ConnectToIP("localhost", driver_port)
+	test_driver2, connErr2 := ConnectToIP("localhost", int64(daemon.Port))
 	verifyNoError(t, connErr2)
 	test_driver2.Quit()
 }
@@ -306,8 +266,9 @@ func TestIntegrationPending(t *testing.T) {
 	resp, _ := driver.Update(testRrdFile, update_values...)
 	verifyUpdateResponseForN(t, resp, update_values)
 
-	resp, _ = driver.Pending(testRrdFile)
-	verifyPendingResponseForN(t, resp, update_values)
+	pending, err := driver.Pending(testRrdFile)
+	verifyNoError(t, err)
+	verifyPendingForN(t, pending, update_values)
 
 	verifyStatsFresh(t, map[string]uint64{
 		"UpdatesReceived": 1,
@@ -379,12 +340,7 @@ func TestIntegrationFirst(t *testing.T) {
 	// Note: The FIRST command is only recently supported.
 	// Tests included for completeness, but lack of support shouldn't cause test failure.
 	resp1, cmderr := driver.First(testRrdFile, 0)
-	if cmderr != nil {
-		if _, ok := cmderr.(*UnknownCommandError); ok {
-			log(3, "Warning: FIRST is unsupported on this system.")
-			return
-		}
-	}
+	skipIfUnsupported(t, cmderr, "FIRST")
 	verifySuccessResponse(t, resp1)
 
 	timestamp1, err1 := strconv.ParseUint(resp1.Message, 10, 64)
@@ -412,12 +368,7 @@ func TestIntegrationLast(t *testing.T) {
 	// Note: The LAST command is only recently supported.
 	// Tests included for completeness, but lack of support shouldn't cause test failure.
 	resp, cmderr := driver.Last(testRrdFile)
-	if cmderr != nil {
-		if _, ok := cmderr.(*UnknownCommandError); ok {
-			log(3, "Warning: LAST is unsupported on this system.")
-			return
-		}
-	}
+	skipIfUnsupported(t, cmderr, "LAST")
 	verifySuccessResponse(t, resp)
 
 	_, err := strconv.ParseUint(resp.Message, 10, 64)
@@ -425,3 +376,151 @@ func TestIntegrationLast(t *testing.T) {
 		t.Errorf("LAST timestamp %v is not parseable: %v", resp.Message, err)
 	}
 }
+
+func TestIntegrationFetch(t *testing.T) {
+	testSetup(t)
+	defer testTeardown()
+
+	update_values := generateTimestamps(rrdUpdates)
+
+	resp, _ := driver.Update(testRrdFile, update_values...)
+	verifyUpdateResponseForN(t, resp, update_values)
+
+	result, cmderr := driver.Fetch(testRrdFile, "AVERAGE", -1, -1, -1)
+	skipIfUnsupported(t, cmderr, "FETCH")
+	if cmderr != nil {
+		t.Fatalf("FETCH failed: %v", cmderr)
+	}
+
+	if !reflect.DeepEqual(result.DSNames, []string{"test1", "test2", "test3", "test4"}) {
+		t.Errorf("FETCH returned unexpected DS names: %v", result.DSNames)
+	}
+	if len(result.Timestamps) != len(result.Values) {
+		t.Errorf("FETCH returned %d timestamps for %d rows", len(result.Timestamps), len(result.Values))
+	}
+}
+
+func TestIntegrationFetchBinary(t *testing.T) {
+	testSetup(t)
+	defer testTeardown()
+
+	update_values := generateTimestamps(rrdUpdates)
+
+	resp, _ := driver.Update(testRrdFile, update_values...)
+	verifyUpdateResponseForN(t, resp, update_values)
+
+	result, cmderr := driver.FetchBinary(testRrdFile, "AVERAGE", -1, -1, -1)
+	skipIfUnsupported(t, cmderr, "FETCHBIN")
+	if cmderr != nil {
+		t.Fatalf("FETCHBIN failed: %v", cmderr)
+	}
+
+	text, _ := driver.Fetch(testRrdFile, "AVERAGE", result.Start, result.End, -1)
+	if text != nil && !reflect.DeepEqual(text.Timestamps, result.Timestamps) {
+		t.Errorf("FETCHBIN timestamps %v differ from FETCH timestamps %v", result.Timestamps, text.Timestamps)
+	}
+}
+
+func TestIntegrationBatch(t *testing.T) {
+	testSetup(t)
+	defer testTeardown()
+
+	update_values := generateTimestamps(rrdUpdates)
+
+	batch := driver.Batch()
+	for _, value := range update_values {
+		batch.Update(testRrdFile, value)
+	}
+	resp, err := batch.Flush(testRrdFile).Exec()
+	verifyNoError(t, err)
+	verifySuccessResponse(t, resp)
+
+	verifyStatsFresh(t, map[string]uint64{
+		"UpdatesReceived": uint64(len(update_values)),
+		"FlushesReceived": 1,
+	})
+}
+
+func TestIntegrationInfo(t *testing.T) {
+	testSetup(t)
+	defer testTeardown()
+
+	info, cmderr := driver.Info(testRrdFile)
+	skipIfUnsupported(t, cmderr, "INFO")
+	if cmderr != nil {
+		t.Fatalf("INFO failed: %v", cmderr)
+	}
+
+	if err := info.MatchesSchema(-1, defineDS, defineRRA); err != nil {
+		t.Errorf("INFO does not match the schema the RRD was created with: %v", err)
+	}
+}
+
+func TestIntegrationList(t *testing.T) {
+	testSetup(t)
+	defer testTeardown()
+
+	paths, cmderr := driver.List("/", true)
+	skipIfUnsupported(t, cmderr, "LIST")
+	if cmderr != nil {
+		t.Fatalf("LIST failed: %v", cmderr)
+	}
+
+	found := false
+	for _, path := range paths {
+		if strings.HasSuffix(path, testRrdFile) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("LIST did not return %v: %v", testRrdFile, paths)
+	}
+}
+
+func TestIntegrationQueue(t *testing.T) {
+	testSetup(t)
+	defer testTeardown()
+
+	_, cmderr := driver.Queue()
+	skipIfUnsupported(t, cmderr, "QUEUE")
+	if cmderr != nil {
+		t.Errorf("QUEUE failed: %v", cmderr)
+	}
+}
+
+func TestIntegrationWithSuspended(t *testing.T) {
+	testSetup(t)
+	defer testTeardown()
+
+	err := driver.WithSuspended([]string{testRrdFile}, func() error {
+		update_values := generateTimestamps(rrdUpdates)
+		resp, err := driver.Update(testRrdFile, update_values...)
+		verifyUpdateResponseForN(t, resp, update_values)
+		return err
+	})
+	skipIfUnsupported(t, err, "SUSPEND")
+	if err != nil {
+		t.Errorf("WithSuspended failed: %v", err)
+	}
+}
+
+func TestIntegrationCapabilities(t *testing.T) {
+	testSetup(t)
+	defer testTeardown()
+
+	capabilities, err := driver.Capabilities()
+	verifyNoError(t, err)
+
+	for _, command := range []string{"UPDATE", "FLUSH", "PENDING", "STATS"} {
+		if !capabilities.Supports(command) {
+			t.Errorf("HELP does not list %v: %+v", command, capabilities.Commands)
+		}
+	}
+
+	if !capabilities.Supports("FIRST") {
+		_, err := driver.First(testRrdFile, 0)
+		if _, ok := err.(*UnsupportedCommandError); !ok {
+			t.Errorf("UnsupportedCommandError expected from FIRST, but %T received.", err)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package rrdcached
+
+import "context"
+
+// Invoker sends a command and reads its reply.
+type Invoker func(ctx context.Context, command string, args []string) (*Response, error)
+
+// Interceptor wraps every command a client sends, e.g. to trace or time it. It must call next
+// to send the command, and may change ctx or args before doing so. The connection is only
+// taken once the last interceptor calls next, so an interceptor may use the client itself.
+//
+// Commands that return more than a Response pass on only their status line, or for BATCH
+// the final reply; args holds the commands of a BATCH. UpdateAsync runs the interceptors
+// on a goroutine of its own, and returns once they have called next to write the command;
+// they then wait there for its reply.
+type Interceptor func(ctx context.Context, command string, args []string, next Invoker) (*Response, error)
+
+// WithInterceptors adds interceptors to the client. The first one is outermost.
+func WithInterceptors(interceptors ...Interceptor) Option {
+	return func(r *Rrdcached) {
+		r.interceptors = append(r.interceptors, interceptors...)
+	}
+}
+
+// invoke passes the command through the interceptors to send.
+func (r *Rrdcached) invoke(ctx context.Context, command string, args []string, send Invoker) (*Response, error) {
+	return chain(r.interceptors, send)(ctx, command, args)
+}
+
+func chain(interceptors []Interceptor, send Invoker) Invoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], send
+		send = func(ctx context.Context, command string, args []string) (*Response, error) {
+			return interceptor(ctx, command, args, next)
+		}
+	}
+	return send
+}
@@ -0,0 +1,94 @@
+package rrdcached
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingInterceptor notes each command it sees, before and after sending it.
+func recordingInterceptor(name string, seen *[]string) Interceptor {
+	return func(ctx context.Context, command string, args []string, next Invoker) (*Response, error) {
+		*seen = append(*seen, fmt.Sprintf("%s> %s %v", name, command, args))
+		resp, err := next(ctx, command, args)
+		status := 0
+		if resp != nil {
+			status = resp.Status
+		}
+		*seen = append(*seen, fmt.Sprintf("%s< %d %v", name, status, err))
+		return resp, err
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	_, fakeDriver := prepTestData("", "0 Successfully flushed /tmp/foo.rrd.")
+	var seen []string
+	WithInterceptors(recordingInterceptor("outer", &seen), recordingInterceptor("inner", &seen))(fakeDriver)
+
+	resp, err := fakeDriver.Flush("foo.rrd")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, resp.Status)
+	assert.Equal(t, []string{
+		"outer> FLUSH [foo.rrd]",
+		"inner> FLUSH [foo.rrd]",
+		"inner< 0 <nil>",
+		"outer< 0 <nil>",
+	}, seen)
+}
+
+func TestInterceptorSeesError(t *testing.T) {
+	_, fakeDriver := prepTestData("", "-1 No such file: /tmp/foo.rrd")
+	var seen []string
+	WithInterceptors(recordingInterceptor("hook", &seen))(fakeDriver)
+
+	_, err := fakeDriver.Last("foo.rrd")
+
+	assert.IsType(t, &FileDoesNotExistError{}, err)
+	assert.Equal(t, []string{"hook> LAST [foo.rrd]", "hook< -1 No such file: /tmp/foo.rrd"}, seen)
+}
+
+func TestInterceptorChangesArgs(t *testing.T) {
+	_, fakeDriver := prepTestData("", "0 errors, enqueued 1 value(s).")
+	WithInterceptors(func(ctx context.Context, command string, args []string, next Invoker) (*Response, error) {
+		return next(ctx, command, append([]string{"prefix/" + args[0]}, args[1:]...))
+	})(fakeDriver)
+
+	_, err := fakeDriver.Update("foo.rrd", "1438354679:10")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "UPDATE prefix/foo.rrd 1438354679:10\n", fakeDriver.Rrdio.(*fakeDataTransport).written)
+}
+
+func TestInterceptorMayUseClient(t *testing.T) {
+	fakeDriver := &Rrdcached{Rrdio: &fakeEchoTransport{}}
+	var before *Response
+	WithInterceptors(func(ctx context.Context, command string, args []string, next Invoker) (*Response, error) {
+		if command == "UPDATE" {
+			before, _ = fakeDriver.Last(args[0])
+		}
+		return next(ctx, command, args)
+	})(fakeDriver)
+
+	resp, err := fakeDriver.Update("foo.rrd", "1438354679:10")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "UPDATE foo.rrd 1438354679:10", resp.Message)
+	assert.Equal(t, "LAST foo.rrd", before.Message)
+}
+
+func TestInterceptorBatch(t *testing.T) {
+	_, fakeDriver := prepSequenceTestData(
+		"0 Go ahead.  End with dot '.' on its own line.",
+		"0 errors",
+	)
+	var seen []string
+	WithInterceptors(recordingInterceptor("hook", &seen))(fakeDriver)
+
+	_, err := fakeDriver.Batch().Update("foo.rrd", "1438354679:10").Flush("foo.rrd").Exec()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hook> BATCH [UPDATE foo.rrd 1438354679:10 FLUSH foo.rrd]", "hook< 0 <nil>"}, seen)
+}
@@ -0,0 +1,29 @@
+// Package protocol holds the parts of rrdcached's line protocol shared by the client and its fake server.
+package protocol
+
+import "strings"
+
+// Fields splits a command line into its fields the way rrdcached's buffer_get_field does:
+// every space ends a field, so consecutive spaces give empty fields, and the character after
+// a backslash is taken literally. A last field ending in a lone backslash cannot be read by
+// the daemon and is left out, as if the command had been sent without it.
+func Fields(line string) []string {
+	var fields []string
+	var field strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+			if i == len(line) {
+				return fields
+			}
+			field.WriteByte(line[i])
+		case ' ':
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteByte(line[i])
+		}
+	}
+	return append(fields, field.String())
+}
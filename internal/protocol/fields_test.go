@@ -0,0 +1,26 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFields(t *testing.T) {
+	for _, tc := range []struct {
+		line   string
+		fields []string
+	}{
+		{"", []string{""}},
+		{"FLUSHALL", []string{"FLUSHALL"}},
+		{"UPDATE foo.rrd 1438354679:10", []string{"UPDATE", "foo.rrd", "1438354679:10"}},
+		{`UPDATE my\ rrds/foo.rrd N:1`, []string{"UPDATE", "my rrds/foo.rrd", "N:1"}},
+		{`FLUSH C:\\rrd\\foo.rrd`, []string{"FLUSH", `C:\rrd\foo.rrd`}},
+		{"UPDATE  foo.rrd", []string{"UPDATE", "", "foo.rrd"}},
+		{"FLUSH foo.rrd ", []string{"FLUSH", "foo.rrd", ""}},
+		{`FLUSH foo.rrd\`, []string{"FLUSH"}},
+		{`FLUSH foo.rrd\\`, []string{"FLUSH", `foo.rrd\`}},
+	} {
+		assert.Equal(t, tc.fields, Fields(tc.line), "line %q", tc.line)
+	}
+}
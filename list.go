@@ -0,0 +1,165 @@
+package rrdcached
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// List returns the RRDs below path, as known to the daemon.
+// Use ListIter for trees too large to hold in a single reply string.
+func (r *Rrdcached) List(path string, recursive bool) ([]string, error) {
+	return r.ListContext(context.Background(), path, recursive)
+}
+
+func (r *Rrdcached) ListContext(ctx context.Context, path string, recursive bool) ([]string, error) {
+	resp, err := r.exec(ctx, "LIST", listParams(path, recursive)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Lines(), nil
+}
+
+func listParams(path string, recursive bool) []string {
+	if recursive {
+		return []string{"RECURSIVE", path}
+	}
+	return []string{path}
+}
+
+// ListIterator reads the reply to LIST one path at a time.
+// It holds the connection until the reply has been read to the end or Close is called.
+type ListIterator struct {
+	stream    RRDStreamIO
+	in        io.Reader
+	remaining int
+	lines     []string
+	path      string
+	err       error
+	release   func()
+}
+
+// ListIter is List without buffering the reply. The transport must implement RRDStreamIO.
+func (r *Rrdcached) ListIter(path string, recursive bool) (*ListIterator, error) {
+	return r.ListIterContext(context.Background(), path, recursive)
+}
+
+// ListIterContext bounds the whole iteration by ctx, not just the first reply line.
+// Interceptors only see the first reply line, as the rest is read after they return.
+// An interceptor that answers without calling next gives the paths in the lines of its reply.
+func (r *Rrdcached) ListIterContext(ctx context.Context, path string, recursive bool) (*ListIterator, error) {
+	stream, ok := r.Rrdio.(RRDStreamIO)
+	if !ok {
+		return nil, fmt.Errorf("transport %T cannot stream replies", r.Rrdio)
+	}
+
+	var in io.Reader
+	// locked reports whether the connection is held, as it is from a successful next onwards.
+	locked := false
+	resp, err := r.invoke(ctx, "LIST", listParams(path, recursive), func(ctx context.Context, command string, args []string) (resp *Response, err error) {
+		if !locked {
+			r.lock()
+			locked = true
+		}
+
+		err = r.withReconnect(ctx, command, func() error {
+			err := r.writeCommand(command, args...)
+			if err != nil {
+				return err
+			}
+
+			in = r.stream()
+			line, err := stream.ReadLine(in)
+			if err != nil {
+				return err
+			}
+			r.logReply(line)
+			resp, err = parseResponse(r.sent, line)
+			return err
+		})
+		if err != nil {
+			r.mu.Unlock()
+			locked = false
+		}
+		return resp, err
+	})
+	if err != nil {
+		if locked {
+			r.mu.Unlock()
+		}
+		return nil, err
+	}
+	if !locked {
+		// An interceptor answered without asking the daemon, so its reply is all there is to read.
+		it := &ListIterator{}
+		if resp != nil {
+			it.lines = resp.Lines()
+		}
+		return it, nil
+	}
+
+	it := &ListIterator{
+		stream:    stream,
+		in:        in,
+		remaining: resp.Status,
+	}
+	unbind := r.bindContext(ctx)
+	it.release = func() {
+		it.err = unbind(it.err)
+		r.mu.Unlock()
+	}
+	it.done()
+	return it, nil
+}
+
+// done hands the connection back once nothing more will be read from it.
+func (it *ListIterator) done() {
+	if it.release != nil && (it.err != nil || it.remaining <= 0) {
+		it.release()
+		it.release = nil
+	}
+}
+
+// Next advances to the next path, and reports false once the reply is exhausted or an error occurred.
+func (it *ListIterator) Next() bool {
+	defer it.done()
+
+	for len(it.lines) > 0 {
+		it.path, it.lines = strings.TrimSpace(it.lines[0]), it.lines[1:]
+		if it.path != "" {
+			return true
+		}
+	}
+	for it.err == nil && it.remaining > 0 {
+		line, err := it.stream.ReadLine(it.in)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.remaining--
+
+		it.path = strings.TrimSpace(line)
+		if it.path != "" {
+			return true
+		}
+	}
+	it.path = ""
+	return false
+}
+
+func (it *ListIterator) Path() string {
+	return it.path
+}
+
+func (it *ListIterator) Err() error {
+	return it.err
+}
+
+// Close discards the rest of the reply.
+func (it *ListIterator) Close() error {
+	for it.Next() {
+	}
+	return it.err
+}
@@ -0,0 +1,133 @@
+package rrdcached
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testListResponse = "3 RRDs\n/tmp/foo.rrd\n/tmp/sub/bar.rrd\n/tmp/sub/baz.rrd\n"
+
+func TestList(t *testing.T) {
+	expected, fakeDriver := prepTestData(
+		"LIST /tmp\n",
+		testListResponse,
+	)
+
+	paths, err := fakeDriver.List("/tmp", false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, fakeDriver.Rrdio)
+	assert.Equal(t, []string{"/tmp/foo.rrd", "/tmp/sub/bar.rrd", "/tmp/sub/baz.rrd"}, paths)
+}
+
+func TestListRecursive(t *testing.T) {
+	expected, fakeDriver := prepTestData(
+		"LIST RECURSIVE /tmp\n",
+		testListResponse,
+	)
+
+	_, err := fakeDriver.List("/tmp", true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, fakeDriver.Rrdio)
+}
+
+func TestListEmpty(t *testing.T) {
+	_, fakeDriver := prepTestData(
+		"LIST /tmp\n",
+		"0 RRDs",
+	)
+
+	paths, err := fakeDriver.List("/tmp", false)
+
+	assert.NoError(t, err)
+	assert.Empty(t, paths)
+}
+
+func TestListWithoutExistingPath(t *testing.T) {
+	_, fakeDriver := prepTestData(
+		"LIST /nope\n",
+		"-1 No such file or directory",
+	)
+
+	_, err := fakeDriver.List("/nope", false)
+
+	assert.IsType(t, &FileDoesNotExistError{}, err)
+}
+
+func TestListIter(t *testing.T) {
+	transport, fakeDriver := prepStreamTestData([]byte(testListResponse + "0 Gone!\n"))
+
+	it, err := fakeDriver.ListIter("/tmp", true)
+	assert.NoError(t, err)
+
+	var paths []string
+	for it.Next() {
+		paths = append(paths, it.Path())
+	}
+
+	assert.NoError(t, it.Err())
+	assert.Equal(t, "LIST RECURSIVE /tmp\n", transport.written)
+	assert.Equal(t, []string{"/tmp/foo.rrd", "/tmp/sub/bar.rrd", "/tmp/sub/baz.rrd"}, paths)
+
+	// Only the LIST reply was consumed.
+	line, _ := transport.ReadLine(nil)
+	assert.Equal(t, "0 Gone!\n", line)
+}
+
+func TestListIterClose(t *testing.T) {
+	transport, fakeDriver := prepStreamTestData([]byte(testListResponse + "0 Gone!\n"))
+
+	it, err := fakeDriver.ListIter("/tmp", false)
+	assert.NoError(t, err)
+	assert.True(t, it.Next())
+	assert.NoError(t, it.Close())
+	assert.False(t, it.Next())
+
+	line, _ := transport.ReadLine(nil)
+	assert.Equal(t, "0 Gone!\n", line)
+}
+
+func TestListIterWithoutExistingPath(t *testing.T) {
+	_, fakeDriver := prepStreamTestData([]byte("-1 No such file or directory\n"))
+
+	_, err := fakeDriver.ListIter("/nope", false)
+
+	assert.IsType(t, &FileDoesNotExistError{}, err)
+}
+
+func TestListIterInterceptorFails(t *testing.T) {
+	_, fakeDriver := prepStreamTestData([]byte(testListResponse))
+	WithInterceptors(func(ctx context.Context, command string, args []string, next Invoker) (*Response, error) {
+		next(ctx, command, args)
+		return nil, errors.New("refused by interceptor")
+	})(fakeDriver)
+
+	_, err := fakeDriver.ListIter("/tmp", false)
+
+	assert.EqualError(t, err, "refused by interceptor")
+	assert.True(t, fakeDriver.mu.TryLock(), "the connection is still held")
+}
+
+func TestListIterInterceptorAnswers(t *testing.T) {
+	transport, fakeDriver := prepStreamTestData(nil)
+	WithInterceptors(func(ctx context.Context, command string, args []string, next Invoker) (*Response, error) {
+		return parseResponse(sentCommand{}, testListResponse)
+	})(fakeDriver)
+
+	it, err := fakeDriver.ListIter("/tmp", false)
+	assert.NoError(t, err)
+
+	var paths []string
+	for it.Next() {
+		paths = append(paths, it.Path())
+	}
+
+	assert.NoError(t, it.Close())
+	assert.Equal(t, []string{"/tmp/foo.rrd", "/tmp/sub/bar.rrd", "/tmp/sub/baz.rrd"}, paths)
+	assert.Empty(t, transport.written)
+	assert.True(t, fakeDriver.mu.TryLock(), "the connection is held")
+}
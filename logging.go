@@ -0,0 +1,88 @@
+package rrdcached
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// Logger receives the client's events as a message followed by alternating keys and values, as slog takes them.
+//
+// Commands and their replies are logged at LevelDebug with "command", "filename", "status" and "latency",
+// connects and reconnects at LevelInfo, and failed commands and connects at LevelWarn and LevelError with "error".
+// Latency runs from the start of the command, including any reconnects.
+// Replies to UpdateAsync are logged from their reader goroutine, so Log must be safe for concurrent use.
+type Logger interface {
+	Log(level LogLevel, msg string, keyvals ...interface{})
+}
+
+func (level LogLevel) String() string {
+	switch level {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return "LEVEL(" + strconv.Itoa(int(level)) + ")"
+}
+
+// sentCommand is the command a reply or failure is logged for.
+type sentCommand struct {
+	command  string
+	filename string
+	start    time.Time
+}
+
+func (sent sentCommand) fields(keyvals ...interface{}) []interface{} {
+	return append([]interface{}{"command", sent.command, "filename", sent.filename}, append(keyvals, "latency", time.Since(sent.start))...)
+}
+
+// commandFilename picks the file, or for LIST the path, a command acts on.
+func commandFilename(command string, args []string) string {
+	if i := filenameIndex(command, args); i >= 0 {
+		return args[i]
+	}
+	return ""
+}
+
+// filenameIndex is the position of the filename among args, or -1 if there is none.
+func filenameIndex(command string, args []string) int {
+	switch {
+	case len(args) == 0:
+		return -1
+	case command == "LIST":
+		return len(args) - 1
+	}
+	return 0
+}
+
+func (r *Rrdcached) log(level LogLevel, msg string, keyvals ...interface{}) {
+	if r.logger != nil {
+		r.logger.Log(level, msg, keyvals...)
+	}
+}
+
+func (r *Rrdcached) logReply(data string) {
+	if r.logger == nil {
+		return
+	}
+	status, _ := strconv.Atoi(strings.SplitN(data, " ", 2)[0])
+	r.log(LevelDebug, "reply", r.sent.fields("status", status)...)
+}
+
+func (r *Rrdcached) logFailure(err error) {
+	r.log(LevelWarn, "command failed", r.sent.fields("error", err)...)
+}
@@ -0,0 +1,73 @@
+package rrdcached
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogFailure(t *testing.T) {
+	logger := &fakeLogger{}
+	_, fakeDriver := prepTestData("", "-1 No such file: /tmp/foo.rrd")
+	fakeDriver.logger = logger
+
+	_, err := fakeDriver.Flush("foo.rrd")
+
+	assert.Error(t, err)
+	assert.Equal(t, []string{
+		"DEBUG command command=FLUSH filename=foo.rrd",
+		"DEBUG reply command=FLUSH filename=foo.rrd status=-1",
+		"WARN command failed command=FLUSH filename=foo.rrd error=No such file: /tmp/foo.rrd",
+	}, logger.lines)
+}
+
+func TestLogReconnect(t *testing.T) {
+	logger := &fakeLogger{}
+	socket := startFakeDaemon(t, hangUpFirst("0 Successfully flushed /tmp/foo.rrd.\n"))
+	driver, err := New(socket, WithLogger(logger), WithReconnect(testReconnectPolicy))
+	assert.NoError(t, err)
+
+	_, err = driver.Flush("foo.rrd")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"INFO connected address=" + socket,
+		"DEBUG command command=FLUSH filename=foo.rrd",
+		"INFO reconnecting command=FLUSH error=EOF",
+		"INFO connected address=" + socket,
+		"DEBUG command command=FLUSH filename=foo.rrd",
+		"DEBUG reply command=FLUSH filename=foo.rrd status=0",
+	}, logger.lines)
+}
+
+func TestCommandFilename(t *testing.T) {
+	assert.Equal(t, "foo.rrd", commandFilename("UPDATE", []string{"foo.rrd", "1438354679:10"}))
+	assert.Equal(t, "foo", commandFilename("LIST", []string{"RECURSIVE", "foo"}))
+	assert.Equal(t, "", commandFilename("STATS", nil))
+}
+
+func TestSlogLogger(t *testing.T) {
+	var out bytes.Buffer
+	handler := slog.NewTextHandler(&out, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if attr.Key == slog.TimeKey || attr.Key == "latency" {
+				return slog.Attr{}
+			}
+			return attr
+		},
+	})
+	_, fakeDriver := prepTestData("", "0 Successfully flushed /tmp/foo.rrd.")
+	fakeDriver.logger = NewSlogLogger(slog.New(handler))
+
+	_, err := fakeDriver.Flush("foo.rrd")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"level=DEBUG msg=command command=FLUSH filename=foo.rrd",
+		"level=DEBUG msg=reply command=FLUSH filename=foo.rrd status=0",
+	}, strings.Split(strings.TrimSpace(out.String()), "\n"))
+}
@@ -0,0 +1,102 @@
+package rrdcached
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds of the latency histogram kept by Metrics.
+var LatencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// MetricKey identifies the commands counted together. Status is "ok", "error" if the daemon
+// refused the command, "timeout" or "connection_error".
+type MetricKey struct {
+	Command string
+	Status  string
+}
+
+// CommandMetrics counts the commands sent for one MetricKey.
+// Buckets[i] is the number of those that took at most LatencyBuckets[i], as in a Prometheus histogram.
+type CommandMetrics struct {
+	Count   uint64
+	Sum     time.Duration
+	Buckets []uint64
+}
+
+// Metrics is an Interceptor that counts commands and their latency by command and status:
+//
+//	metrics := NewMetrics()
+//	New(address, WithInterceptors(metrics.Intercept))
+//
+// One Metrics may be shared by several clients, e.g. those of a pool.
+type Metrics struct {
+	mu       sync.Mutex
+	commands map[MetricKey]*CommandMetrics
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{commands: map[MetricKey]*CommandMetrics{}}
+}
+
+func (m *Metrics) Intercept(ctx context.Context, command string, args []string, next Invoker) (*Response, error) {
+	start := time.Now()
+	resp, err := next(ctx, command, args)
+	m.observe(MetricKey{command, metricStatus(err)}, time.Since(start))
+	return resp, err
+}
+
+func metricStatus(err error) string {
+	var timeoutErr *TimeoutError
+	var connErr *ConnectionError
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.As(err, &timeoutErr):
+		return "timeout"
+	case errors.As(err, &connErr):
+		return "connection_error"
+	}
+	return "error"
+}
+
+func (m *Metrics) observe(key MetricKey, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	metrics, ok := m.commands[key]
+	if !ok {
+		metrics = &CommandMetrics{Buckets: make([]uint64, len(LatencyBuckets))}
+		m.commands[key] = metrics
+	}
+	metrics.Count++
+	metrics.Sum += latency
+	for i := sort.Search(len(LatencyBuckets), func(i int) bool { return latency <= LatencyBuckets[i] }); i < len(LatencyBuckets); i++ {
+		metrics.Buckets[i]++
+	}
+}
+
+// Snapshot copies the metrics gathered so far.
+func (m *Metrics) Snapshot() map[MetricKey]CommandMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := make(map[MetricKey]CommandMetrics, len(m.commands))
+	for key, metrics := range m.commands {
+		copied := *metrics
+		copied.Buckets = append([]uint64(nil), metrics.Buckets...)
+		snapshot[key] = copied
+	}
+	return snapshot
+}
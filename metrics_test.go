@@ -0,0 +1,63 @@
+package rrdcached
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics()
+	fakeDriver := &Rrdcached{Rrdio: &fakeEchoTransport{}}
+	WithInterceptors(metrics.Intercept)(fakeDriver)
+
+	for i := 0; i < 3; i++ {
+		_, err := fakeDriver.Update("foo.rrd", fmt.Sprintf("%d:1", i))
+		assert.NoError(t, err)
+	}
+	_, err := fakeDriver.Flush("foo.rrd")
+	assert.NoError(t, err)
+
+	snapshot := metrics.Snapshot()
+	assert.Len(t, snapshot, 2)
+	assert.Equal(t, uint64(3), snapshot[MetricKey{"UPDATE", "ok"}].Count)
+	assert.Equal(t, uint64(1), snapshot[MetricKey{"FLUSH", "ok"}].Count)
+}
+
+func TestMetricsStatus(t *testing.T) {
+	metrics := NewMetrics()
+	_, refused := prepTestData("", "-1 No such file: /tmp/foo.rrd")
+	broken := &Rrdcached{Rrdio: &fakeDataTransport{error: &ConnectionError{fmt.Errorf("write unix ->foo.sock: write: broken pipe")}}}
+	WithInterceptors(metrics.Intercept)(refused)
+	WithInterceptors(metrics.Intercept)(broken)
+
+	refused.Flush("foo.rrd")
+	broken.Flush("foo.rrd")
+
+	snapshot := metrics.Snapshot()
+	assert.Equal(t, uint64(1), snapshot[MetricKey{"FLUSH", "error"}].Count)
+	assert.Equal(t, uint64(1), snapshot[MetricKey{"FLUSH", "connection_error"}].Count)
+	assert.Equal(t, "timeout", metricStatus(&TimeoutError{fmt.Errorf("i/o timeout")}))
+	assert.Equal(t, "connection_error", metricStatus(fmt.Errorf("flush: %w", &ConnectionError{io.EOF})))
+}
+
+func TestMetricsBuckets(t *testing.T) {
+	metrics := NewMetrics()
+	key := MetricKey{"UPDATE", "ok"}
+
+	metrics.observe(key, 500*time.Microsecond)
+	metrics.observe(key, 20*time.Millisecond)
+	metrics.observe(key, time.Minute)
+
+	snapshot := metrics.Snapshot()[key]
+	assert.Equal(t, uint64(3), snapshot.Count)
+	assert.Equal(t, time.Minute+20500*time.Microsecond, snapshot.Sum)
+	assert.Equal(t, []uint64{1, 1, 1, 2, 2, 2, 2, 2}, snapshot.Buckets)
+
+	// A snapshot does not change with later observations.
+	metrics.observe(key, time.Millisecond)
+	assert.Equal(t, uint64(1), snapshot.Buckets[0])
+}
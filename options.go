@@ -0,0 +1,107 @@
+package rrdcached
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// Option configures a client created by New.
+type Option func(r *Rrdcached)
+
+// Dialer opens connections to the daemon. *net.Dialer implements it.
+type Dialer interface {
+	DialContext(ctx context.Context, network string, address string) (net.Conn, error)
+}
+
+// New connects to address, in any of the forms accepted by Connect, configured by options, e.g.
+//
+//	New("unix:/var/run/rrdcached.sock", WithReadTimeout(5*time.Second), WithReconnect(DefaultReconnectPolicy))
+func New(address string, options ...Option) (*Rrdcached, error) {
+	driver, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	for _, option := range options {
+		option(driver)
+	}
+
+	err = driver.connect(context.Background())
+	return driver, err
+}
+
+// WithDialTimeout limits each dial, including those made to reconnect. A dial that runs out of
+// time fails with a ConnectionError, so WithReconnect goes on to its next attempt.
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(r *Rrdcached) {
+		r.dialTimeout = timeout
+	}
+}
+
+// WithReadTimeout limits the time spent reading each reply.
+// A context deadline that is sooner takes precedence.
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(r *Rrdcached) {
+		r.readTimeout = timeout
+	}
+}
+
+// WithWriteTimeout limits the time spent writing each command.
+// A context deadline that is sooner takes precedence.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(r *Rrdcached) {
+		r.writeTimeout = timeout
+	}
+}
+
+// WithDialer replaces the net.Dialer used to connect, e.g. to dial through a proxy.
+// WithKeepAlive has no effect on a custom dialer.
+func WithDialer(dialer Dialer) Option {
+	return func(r *Rrdcached) {
+		r.dialer = dialer
+	}
+}
+
+// WithKeepAlive sets the TCP keep-alive period, as net.Dialer's KeepAlive does:
+// zero keeps Go's default, and a negative period disables keep-alives.
+func WithKeepAlive(period time.Duration) Option {
+	return func(r *Rrdcached) {
+		r.keepAlive = period
+	}
+}
+
+// WithLogger sends the client's events to logger, e.g. NewSlogLogger(slog.Default()).
+func WithLogger(logger Logger) Option {
+	return func(r *Rrdcached) {
+		r.logger = logger
+	}
+}
+
+func WithReconnect(policy ReconnectPolicy) Option {
+	return func(r *Rrdcached) {
+		r.Reconnect = &policy
+	}
+}
+
+func WithTransport(transport RRDIO) Option {
+	return func(r *Rrdcached) {
+		r.Rrdio = transport
+	}
+}
+
+// WithTimestampGuard checks the timestamps of updates with guard before they are sent.
+func WithTimestampGuard(guard *TimestampGuard) Option {
+	return func(r *Rrdcached) {
+		r.guard = guard
+	}
+}
+
+// WithTimestampPrecision sets the number of decimals in the timestamps returned by the client's NowString.
+// rrdcached before 1.4.5 only accepts whole seconds.
+// Update, UpdateAsync and Batch send the values they are given as they are, timestamps included,
+// so it only applies to values built with NowString.
+func WithTimestampPrecision(digits int) Option {
+	return func(r *Rrdcached) {
+		r.precision = digits
+	}
+}
@@ -0,0 +1,137 @@
+package rrdcached
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeLogger struct {
+	lines []string
+}
+
+// Log keeps the message and the fields except latency, which differs between runs.
+func (l *fakeLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	line := level.String() + " " + msg
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		if keyvals[i] != "latency" {
+			line += fmt.Sprintf(" %v=%v", keyvals[i], keyvals[i+1])
+		}
+	}
+	l.lines = append(l.lines, line)
+}
+
+// fakeDialerFunc lets a test stand in for net.Dialer.
+type fakeDialerFunc func(ctx context.Context, network string, address string) (net.Conn, error)
+
+func (f fakeDialerFunc) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	return f(ctx, network, address)
+}
+
+func answerFlush(n int, conn net.Conn, in *bufio.Reader) {
+	for {
+		_, err := in.ReadString('\n')
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("0 Successfully flushed /tmp/foo.rrd.\n"))
+	}
+}
+
+func TestNew(t *testing.T) {
+	socket := startFakeDaemon(t, answerFlush)
+	logger := &fakeLogger{}
+	var dialed string
+
+	driver, err := New("unix:"+socket,
+		WithLogger(logger),
+		WithReadTimeout(time.Second),
+		WithWriteTimeout(time.Second),
+		WithDialTimeout(time.Second),
+		WithReconnect(testReconnectPolicy),
+		WithTimestampPrecision(3),
+		WithDialer(fakeDialerFunc(func(ctx context.Context, network string, address string) (net.Conn, error) {
+			dialed = network + ":" + address
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, address)
+		})),
+	)
+	assert.NoError(t, err)
+
+	resp, err := driver.Flush("foo.rrd")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, resp.Status)
+	assert.Equal(t, "unix:"+socket, dialed)
+	assert.Equal(t, testReconnectPolicy, *driver.Reconnect)
+	assert.Equal(t, []string{
+		"INFO connected address=" + socket,
+		"DEBUG command command=FLUSH filename=foo.rrd",
+		"DEBUG reply command=FLUSH filename=foo.rrd status=0",
+	}, logger.lines)
+	assert.Regexp(t, `^\d+\.\d{3}$`, driver.NowString())
+}
+
+func TestNewWithTransport(t *testing.T) {
+	socket := startFakeDaemon(t, neverReply)
+	transport := &fakeDataTransport{response: "0 Successfully flushed /tmp/foo.rrd."}
+
+	driver, err := New(socket, WithTransport(transport))
+	assert.NoError(t, err)
+
+	resp, err := driver.Flush("foo.rrd")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, resp.Status)
+	assert.Equal(t, "FLUSH foo.rrd\n", transport.written)
+}
+
+func TestNewReadTimeout(t *testing.T) {
+	socket := startFakeDaemon(t, neverReply)
+	driver, err := New(socket, WithReadTimeout(20*time.Millisecond))
+	assert.NoError(t, err)
+
+	_, err = driver.Flush("foo.rrd")
+
+	assert.IsType(t, &TimeoutError{}, err)
+	assert.Nil(t, driver.Conn)
+}
+
+func TestNewReadTimeoutWithContext(t *testing.T) {
+	socket := startFakeDaemon(t, neverReply)
+	driver, err := New(socket, WithReadTimeout(time.Minute))
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = driver.FlushContext(ctx, "foo.rrd")
+
+	assert.IsType(t, &TimeoutError{}, err)
+	assert.True(t, time.Since(start) < 10*time.Second)
+}
+
+func TestNewDialTimeout(t *testing.T) {
+	driver, err := New("localhost", WithDialTimeout(20*time.Millisecond),
+		WithDialer(fakeDialerFunc(func(ctx context.Context, network string, address string) (net.Conn, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})),
+	)
+
+	assert.IsType(t, &ConnectionError{}, err)
+	assert.Nil(t, driver.Conn)
+}
+
+func TestNewInvalidAddress(t *testing.T) {
+	driver, err := New("localhost:rrdcached")
+
+	assert.Nil(t, driver)
+	assert.True(t, strings.Contains(err.Error(), "invalid port"))
+}
@@ -0,0 +1,592 @@
+package rrdcached
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Client is the command set shared by Rrdcached and Pool, so either can be used
+// wherever the other is expected.
+type Client interface {
+	GetStats() (*Stats, error)
+	GetStatsContext(ctx context.Context) (*Stats, error)
+	Create(filename string, start int64, step int64, overwrite bool, ds []string, rra []string) (*Response, error)
+	CreateContext(ctx context.Context, filename string, start int64, step int64, overwrite bool, ds []string, rra []string) (*Response, error)
+	Update(filename string, values ...string) (*Response, error)
+	UpdateContext(ctx context.Context, filename string, values ...string) (*Response, error)
+	UpdateAsync(filename string, values ...string) *Future
+	Pending(filename string) ([]string, error)
+	PendingContext(ctx context.Context, filename string) ([]string, error)
+	Forget(filename string) (*Response, error)
+	ForgetContext(ctx context.Context, filename string) (*Response, error)
+	Flush(filename string) (*Response, error)
+	FlushContext(ctx context.Context, filename string) (*Response, error)
+	Wrote(filename string) (*Response, error)
+	WroteContext(ctx context.Context, filename string) (*Response, error)
+	FlushAll() (*Response, error)
+	FlushAllContext(ctx context.Context) (*Response, error)
+	First(filename string, rraIndex int) (*Response, error)
+	FirstContext(ctx context.Context, filename string, rraIndex int) (*Response, error)
+	Last(filename string) (*Response, error)
+	LastContext(ctx context.Context, filename string) (*Response, error)
+	Fetch(filename string, cf string, start int64, end int64, step int64) (*FetchResult, error)
+	FetchContext(ctx context.Context, filename string, cf string, start int64, end int64, step int64) (*FetchResult, error)
+	FetchBinary(filename string, cf string, start int64, end int64, step int64) (*FetchResult, error)
+	FetchBinaryContext(ctx context.Context, filename string, cf string, start int64, end int64, step int64) (*FetchResult, error)
+	Info(filename string) (*RRDInfo, error)
+	InfoContext(ctx context.Context, filename string) (*RRDInfo, error)
+	List(path string, recursive bool) ([]string, error)
+	ListContext(ctx context.Context, path string, recursive bool) ([]string, error)
+	ListIter(path string, recursive bool) (*ListIterator, error)
+	ListIterContext(ctx context.Context, path string, recursive bool) (*ListIterator, error)
+	Queue() ([]QueueEntry, error)
+	QueueContext(ctx context.Context) ([]QueueEntry, error)
+	Suspend(filename string) (*Response, error)
+	SuspendContext(ctx context.Context, filename string) (*Response, error)
+	Resume(filename string) (*Response, error)
+	ResumeContext(ctx context.Context, filename string) (*Response, error)
+	SuspendAll() (*Response, error)
+	SuspendAllContext(ctx context.Context) (*Response, error)
+	ResumeAll() (*Response, error)
+	ResumeAllContext(ctx context.Context) (*Response, error)
+	WithSuspended(files []string, fn func() error) error
+	WithSuspendedContext(ctx context.Context, files []string, fn func() error) error
+	Capabilities() (*Capabilities, error)
+	CapabilitiesContext(ctx context.Context) (*Capabilities, error)
+	Batch() *Batch
+	NowString() string
+	Quit()
+}
+
+var (
+	_ Client = (*Rrdcached)(nil)
+	_ Client = (*Pool)(nil)
+)
+
+const (
+	poolRedialDelay    = 100 * time.Millisecond
+	poolMaxRedialDelay = 30 * time.Second
+)
+
+// Pool spreads commands over several connections to the same daemon.
+// Each command borrows an idle connection for its duration, waiting if all are busy.
+// Connections that fail with a ConnectionError, or a health check, are closed
+// and redialed in the background.
+type Pool struct {
+	dial   func() (*Rrdcached, error)
+	idle   chan *Rrdcached
+	closed chan struct{}
+	// precision is that of the first connection, for NowString.
+	precision int
+
+	// mu orders redials against Close, so no redial starts once Close is waiting for them.
+	mu       sync.Mutex
+	isClosed bool
+	wg       sync.WaitGroup
+}
+
+// NewPool dials size connections using dial, e.g.
+//
+//	NewPool(8, time.Minute, func() (*Rrdcached, error) { return ConnectToSocket(socket) })
+//
+// Idle connections are checked every healthCheckInterval, or never if it is zero.
+func NewPool(size int, healthCheckInterval time.Duration, dial func() (*Rrdcached, error)) (*Pool, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("pool size must be at least 1, got %d", size)
+	}
+
+	p := &Pool{
+		dial:   dial,
+		idle:   make(chan *Rrdcached, size),
+		closed: make(chan struct{}),
+	}
+
+	for i := 0; i < size; i++ {
+		r, err := dial()
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		if i == 0 {
+			p.precision = r.precision
+		}
+		p.idle <- r
+	}
+
+	if healthCheckInterval > 0 {
+		p.wg.Add(1)
+		go p.healthCheck(healthCheckInterval)
+	}
+
+	return p, nil
+}
+
+// Close closes every connection. Commands in flight finish first, and their connections are closed when returned.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if !p.isClosed {
+		p.isClosed = true
+		close(p.closed)
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+
+	for {
+		select {
+		case r := <-p.idle:
+			r.Quit()
+			discardConn(r)
+		default:
+			return nil
+		}
+	}
+}
+
+// get waits for an idle connection, or until ctx is done.
+func (p *Pool) get(ctx context.Context) (*Rrdcached, error) {
+	select {
+	case <-p.closed:
+		return nil, &ConnectionError{fmt.Errorf("RRDCacheD pool is closed.")}
+	default:
+	}
+
+	select {
+	case r := <-p.idle:
+		return r, nil
+	case <-p.closed:
+		return nil, &ConnectionError{fmt.Errorf("RRDCacheD pool is closed.")}
+	case <-ctx.Done():
+		return nil, &TimeoutError{ctx.Err()}
+	}
+}
+
+// put returns a connection to the pool, or replaces it if err shows it to be broken.
+// A connection whose command timed out has been closed, so it is replaced as well.
+func (p *Pool) put(r *Rrdcached, err error) {
+	var connErr *ConnectionError
+	var timeoutErr *TimeoutError
+	if errors.As(err, &connErr) || errors.As(err, &timeoutErr) {
+		p.replace(r)
+		return
+	}
+
+	select {
+	case <-p.closed:
+		discardConn(r)
+	default:
+		p.idle <- r
+	}
+}
+
+func (p *Pool) replace(r *Rrdcached) {
+	discardConn(r)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.isClosed {
+		return
+	}
+
+	p.wg.Add(1)
+	go p.redial()
+}
+
+func (p *Pool) redial() {
+	defer p.wg.Done()
+
+	delay := poolRedialDelay
+	for {
+		r, err := p.dial()
+		if err == nil {
+			p.idle <- r
+			return
+		}
+
+		select {
+		case <-p.closed:
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > poolMaxRedialDelay {
+			delay = poolMaxRedialDelay
+		}
+	}
+}
+
+func (p *Pool) healthCheck(interval time.Duration) {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.closed:
+			return
+		case <-ticker.C:
+			p.checkIdle()
+		}
+	}
+}
+
+// checkIdle sends STATS over each idle connection, as rrdcached has no cheaper no-op.
+func (p *Pool) checkIdle() {
+	for i := len(p.idle); i > 0; i-- {
+		select {
+		case r := <-p.idle:
+			_, err := r.exec(context.Background(), "STATS")
+			if err != nil {
+				p.replace(r)
+			} else {
+				p.put(r, nil)
+			}
+		default:
+			return
+		}
+	}
+}
+
+func discardConn(r *Rrdcached) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.Conn != nil {
+		r.Conn.Close()
+	}
+}
+
+func (p *Pool) do(ctx context.Context, fn func(r *Rrdcached) error) error {
+	r, err := p.get(ctx)
+	if err != nil {
+		return err
+	}
+	err = fn(r)
+	p.put(r, err)
+	return err
+}
+
+// ----------------------------------------------------------
+
+func (p *Pool) GetStats() (*Stats, error) {
+	return p.GetStatsContext(context.Background())
+}
+
+func (p *Pool) GetStatsContext(ctx context.Context) (stats *Stats, err error) {
+	err = p.do(ctx, func(r *Rrdcached) error {
+		stats, err = r.GetStatsContext(ctx)
+		return err
+	})
+	return stats, err
+}
+
+func (p *Pool) Create(filename string, start int64, step int64, overwrite bool, ds []string, rra []string) (*Response, error) {
+	return p.CreateContext(context.Background(), filename, start, step, overwrite, ds, rra)
+}
+
+func (p *Pool) CreateContext(ctx context.Context, filename string, start int64, step int64, overwrite bool, ds []string, rra []string) (resp *Response, err error) {
+	err = p.do(ctx, func(r *Rrdcached) error {
+		resp, err = r.CreateContext(ctx, filename, start, step, overwrite, ds, rra)
+		return err
+	})
+	return resp, err
+}
+
+func (p *Pool) Update(filename string, values ...string) (*Response, error) {
+	return p.UpdateContext(context.Background(), filename, values...)
+}
+
+func (p *Pool) UpdateContext(ctx context.Context, filename string, values ...string) (resp *Response, err error) {
+	err = p.do(ctx, func(r *Rrdcached) error {
+		resp, err = r.UpdateContext(ctx, filename, values...)
+		return err
+	})
+	return resp, err
+}
+
+// UpdateAsync keeps the connection out of the pool until the reply has been read, so that
+// a connection the reply shows to be broken is replaced rather than handed to the next command.
+// It returns as soon as the command has been written, but waits for an idle connection first.
+func (p *Pool) UpdateAsync(filename string, values ...string) *Future {
+	r, err := p.get(context.Background())
+	if err != nil {
+		return newFuture().resolve(nil, err)
+	}
+
+	f := r.UpdateAsync(filename, values...)
+	go func() {
+		<-f.Done()
+		p.put(r, f.err)
+	}()
+	return f
+}
+
+func (p *Pool) Pending(filename string) ([]string, error) {
+	return p.PendingContext(context.Background(), filename)
+}
+
+func (p *Pool) PendingContext(ctx context.Context, filename string) (updates []string, err error) {
+	err = p.do(ctx, func(r *Rrdcached) error {
+		updates, err = r.PendingContext(ctx, filename)
+		return err
+	})
+	return updates, err
+}
+
+func (p *Pool) Forget(filename string) (*Response, error) {
+	return p.ForgetContext(context.Background(), filename)
+}
+
+func (p *Pool) ForgetContext(ctx context.Context, filename string) (resp *Response, err error) {
+	err = p.do(ctx, func(r *Rrdcached) error {
+		resp, err = r.ForgetContext(ctx, filename)
+		return err
+	})
+	return resp, err
+}
+
+func (p *Pool) Flush(filename string) (*Response, error) {
+	return p.FlushContext(context.Background(), filename)
+}
+
+func (p *Pool) FlushContext(ctx context.Context, filename string) (resp *Response, err error) {
+	err = p.do(ctx, func(r *Rrdcached) error {
+		resp, err = r.FlushContext(ctx, filename)
+		return err
+	})
+	return resp, err
+}
+
+func (p *Pool) Wrote(filename string) (*Response, error) {
+	return p.WroteContext(context.Background(), filename)
+}
+
+func (p *Pool) WroteContext(ctx context.Context, filename string) (resp *Response, err error) {
+	err = p.do(ctx, func(r *Rrdcached) error {
+		resp, err = r.WroteContext(ctx, filename)
+		return err
+	})
+	return resp, err
+}
+
+func (p *Pool) FlushAll() (*Response, error) {
+	return p.FlushAllContext(context.Background())
+}
+
+func (p *Pool) FlushAllContext(ctx context.Context) (resp *Response, err error) {
+	err = p.do(ctx, func(r *Rrdcached) error {
+		resp, err = r.FlushAllContext(ctx)
+		return err
+	})
+	return resp, err
+}
+
+func (p *Pool) First(filename string, rraIndex int) (*Response, error) {
+	return p.FirstContext(context.Background(), filename, rraIndex)
+}
+
+func (p *Pool) FirstContext(ctx context.Context, filename string, rraIndex int) (resp *Response, err error) {
+	err = p.do(ctx, func(r *Rrdcached) error {
+		resp, err = r.FirstContext(ctx, filename, rraIndex)
+		return err
+	})
+	return resp, err
+}
+
+func (p *Pool) Last(filename string) (*Response, error) {
+	return p.LastContext(context.Background(), filename)
+}
+
+func (p *Pool) LastContext(ctx context.Context, filename string) (resp *Response, err error) {
+	err = p.do(ctx, func(r *Rrdcached) error {
+		resp, err = r.LastContext(ctx, filename)
+		return err
+	})
+	return resp, err
+}
+
+func (p *Pool) Fetch(filename string, cf string, start int64, end int64, step int64) (*FetchResult, error) {
+	return p.FetchContext(context.Background(), filename, cf, start, end, step)
+}
+
+func (p *Pool) FetchContext(ctx context.Context, filename string, cf string, start int64, end int64, step int64) (result *FetchResult, err error) {
+	err = p.do(ctx, func(r *Rrdcached) error {
+		result, err = r.FetchContext(ctx, filename, cf, start, end, step)
+		return err
+	})
+	return result, err
+}
+
+func (p *Pool) FetchBinary(filename string, cf string, start int64, end int64, step int64) (*FetchResult, error) {
+	return p.FetchBinaryContext(context.Background(), filename, cf, start, end, step)
+}
+
+func (p *Pool) FetchBinaryContext(ctx context.Context, filename string, cf string, start int64, end int64, step int64) (result *FetchResult, err error) {
+	err = p.do(ctx, func(r *Rrdcached) error {
+		result, err = r.FetchBinaryContext(ctx, filename, cf, start, end, step)
+		return err
+	})
+	return result, err
+}
+
+func (p *Pool) Info(filename string) (*RRDInfo, error) {
+	return p.InfoContext(context.Background(), filename)
+}
+
+func (p *Pool) InfoContext(ctx context.Context, filename string) (info *RRDInfo, err error) {
+	err = p.do(ctx, func(r *Rrdcached) error {
+		info, err = r.InfoContext(ctx, filename)
+		return err
+	})
+	return info, err
+}
+
+func (p *Pool) List(path string, recursive bool) ([]string, error) {
+	return p.ListContext(context.Background(), path, recursive)
+}
+
+func (p *Pool) ListContext(ctx context.Context, path string, recursive bool) (paths []string, err error) {
+	err = p.do(ctx, func(r *Rrdcached) error {
+		paths, err = r.ListContext(ctx, path, recursive)
+		return err
+	})
+	return paths, err
+}
+
+func (p *Pool) ListIter(path string, recursive bool) (*ListIterator, error) {
+	return p.ListIterContext(context.Background(), path, recursive)
+}
+
+// ListIterContext keeps its connection out of the pool until the iterator is exhausted or closed.
+func (p *Pool) ListIterContext(ctx context.Context, path string, recursive bool) (*ListIterator, error) {
+	r, err := p.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	it, err := r.ListIterContext(ctx, path, recursive)
+	if err != nil {
+		p.put(r, err)
+		return nil, err
+	}
+	if it.release == nil {
+		p.put(r, it.err)
+		return it, nil
+	}
+
+	release := it.release
+	it.release = func() {
+		release()
+		p.put(r, it.err)
+	}
+	return it, nil
+}
+
+func (p *Pool) Queue() ([]QueueEntry, error) {
+	return p.QueueContext(context.Background())
+}
+
+func (p *Pool) QueueContext(ctx context.Context) (entries []QueueEntry, err error) {
+	err = p.do(ctx, func(r *Rrdcached) error {
+		entries, err = r.QueueContext(ctx)
+		return err
+	})
+	return entries, err
+}
+
+func (p *Pool) Suspend(filename string) (*Response, error) {
+	return p.SuspendContext(context.Background(), filename)
+}
+
+func (p *Pool) SuspendContext(ctx context.Context, filename string) (resp *Response, err error) {
+	err = p.do(ctx, func(r *Rrdcached) error {
+		resp, err = r.SuspendContext(ctx, filename)
+		return err
+	})
+	return resp, err
+}
+
+func (p *Pool) Resume(filename string) (*Response, error) {
+	return p.ResumeContext(context.Background(), filename)
+}
+
+func (p *Pool) ResumeContext(ctx context.Context, filename string) (resp *Response, err error) {
+	err = p.do(ctx, func(r *Rrdcached) error {
+		resp, err = r.ResumeContext(ctx, filename)
+		return err
+	})
+	return resp, err
+}
+
+func (p *Pool) SuspendAll() (*Response, error) {
+	return p.SuspendAllContext(context.Background())
+}
+
+func (p *Pool) SuspendAllContext(ctx context.Context) (resp *Response, err error) {
+	err = p.do(ctx, func(r *Rrdcached) error {
+		resp, err = r.SuspendAllContext(ctx)
+		return err
+	})
+	return resp, err
+}
+
+func (p *Pool) ResumeAll() (*Response, error) {
+	return p.ResumeAllContext(context.Background())
+}
+
+func (p *Pool) ResumeAllContext(ctx context.Context) (resp *Response, err error) {
+	err = p.do(ctx, func(r *Rrdcached) error {
+		resp, err = r.ResumeAllContext(ctx)
+		return err
+	})
+	return resp, err
+}
+
+func (p *Pool) WithSuspended(files []string, fn func() error) error {
+	return p.WithSuspendedContext(context.Background(), files, fn)
+}
+
+// WithSuspendedContext borrows a connection for each suspend and resume, rather than one for
+// the whole call, so fn may use the pool itself whatever its size. Files suspended over one
+// connection stay suspended for all of them until resumed.
+func (p *Pool) WithSuspendedContext(ctx context.Context, files []string, fn func() error) error {
+	return withSuspended(ctx, p, files, fn)
+}
+
+// Capabilities probes whichever connection is idle, as they all talk to the same daemon.
+// The other connections are still probed on their own, before the first command that needs it.
+func (p *Pool) Capabilities() (*Capabilities, error) {
+	return p.CapabilitiesContext(context.Background())
+}
+
+func (p *Pool) CapabilitiesContext(ctx context.Context) (capabilities *Capabilities, err error) {
+	err = p.do(ctx, func(r *Rrdcached) error {
+		capabilities, err = r.CapabilitiesContext(ctx)
+		return err
+	})
+	return capabilities, err
+}
+
+func (p *Pool) Batch() *Batch {
+	return &Batch{exec: func(ctx context.Context, commands []batchCommand) (resp *Response, err error) {
+		err = p.do(ctx, func(r *Rrdcached) error {
+			resp, err = r.execBatch(ctx, commands)
+			return err
+		})
+		return resp, err
+	}}
+}
+
+// NowString is Rrdcached.NowString, with the precision its dial function gave the pool's first connection.
+func (p *Pool) NowString() string {
+	return nowString(p.precision)
+}
+
+// Quit is Close, for compatibility with Rrdcached. It closes every connection of the pool,
+// not just one, and the pool cannot be used afterwards.
+func (p *Pool) Quit() {
+	p.Close()
+}
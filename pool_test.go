@@ -0,0 +1,222 @@
+package rrdcached
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDialer hands out clients over fresh fakeEchoTransports, or over whatever
+// transports are queued in next.
+type fakeDialer struct {
+	mu    sync.Mutex
+	dials int32
+	next  []RRDIO
+	err   error
+}
+
+func (d *fakeDialer) dial() (*Rrdcached, error) {
+	atomic.AddInt32(&d.dials, 1)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(d.next) > 0 {
+		transport := d.next[0]
+		d.next = d.next[1:]
+		return &Rrdcached{Rrdio: transport}, nil
+	}
+	return &Rrdcached{Rrdio: &fakeEchoTransport{}}, nil
+}
+
+func (d *fakeDialer) count() int {
+	return int(atomic.LoadInt32(&d.dials))
+}
+
+func TestPool(t *testing.T) {
+	dialer := &fakeDialer{}
+	pool, err := NewPool(4, 0, dialer.dial)
+	assert.NoError(t, err)
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				filename := fmt.Sprintf("foo-%d.rrd", g)
+				resp, err := pool.Update(filename, fmt.Sprintf("%d:1", i))
+				assert.NoError(t, err)
+				assert.Equal(t, fmt.Sprintf("UPDATE %s %d:1", filename, i), resp.Message)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 4, dialer.count())
+	assert.Len(t, pool.idle, 4)
+}
+
+func TestPoolDialError(t *testing.T) {
+	dialer := &fakeDialer{err: &ConnectionError{fmt.Errorf("dial unix foo.sock: connect: no such file or directory")}}
+
+	pool, err := NewPool(2, 0, dialer.dial)
+
+	assert.Nil(t, pool)
+	assert.IsType(t, &ConnectionError{}, err)
+}
+
+func TestPoolReplacesBrokenConnection(t *testing.T) {
+	broken := &fakeDataTransport{error: &ConnectionError{fmt.Errorf("write unix ->foo.sock: write: broken pipe")}}
+	dialer := &fakeDialer{next: []RRDIO{broken}}
+	pool, err := NewPool(1, 0, dialer.dial)
+	assert.NoError(t, err)
+	defer pool.Close()
+
+	_, err = pool.Flush("foo.rrd")
+	assert.IsType(t, &ConnectionError{}, err)
+
+	// The replacement is dialed in the background; the next command waits for it.
+	resp, err := pool.Flush("foo.rrd")
+	assert.NoError(t, err)
+	assert.Equal(t, "FLUSH foo.rrd", resp.Message)
+	assert.Equal(t, 2, dialer.count())
+}
+
+func TestPoolReplacesWrappedBrokenConnection(t *testing.T) {
+	broken := &fakeDataTransport{error: fmt.Errorf("flush: %w", &ConnectionError{fmt.Errorf("write unix ->foo.sock: write: broken pipe")})}
+	dialer := &fakeDialer{next: []RRDIO{broken}}
+	pool, err := NewPool(1, 0, dialer.dial)
+	assert.NoError(t, err)
+	defer pool.Close()
+
+	_, err = pool.Flush("foo.rrd")
+	assert.ErrorAs(t, err, new(*ConnectionError))
+
+	resp, err := pool.Flush("foo.rrd")
+	assert.NoError(t, err)
+	assert.Equal(t, "FLUSH foo.rrd", resp.Message)
+	assert.Equal(t, 2, dialer.count())
+}
+
+func TestPoolKeepsConnectionOnCommandError(t *testing.T) {
+	dialer := &fakeDialer{next: []RRDIO{&fakeDataTransport{response: "-1 No such file: /tmp/foo.rrd"}}}
+	pool, err := NewPool(1, 0, dialer.dial)
+	assert.NoError(t, err)
+	defer pool.Close()
+
+	_, err = pool.Flush("foo.rrd")
+	assert.IsType(t, &FileDoesNotExistError{}, err)
+	_, err = pool.Flush("foo.rrd")
+	assert.IsType(t, &FileDoesNotExistError{}, err)
+	assert.Equal(t, 1, dialer.count())
+}
+
+func TestPoolHealthCheck(t *testing.T) {
+	broken := &fakeDataTransport{error: &ConnectionError{fmt.Errorf("read unix ->foo.sock: EOF")}}
+	dialer := &fakeDialer{next: []RRDIO{broken}}
+	pool, err := NewPool(1, time.Millisecond, dialer.dial)
+	assert.NoError(t, err)
+	defer pool.Close()
+
+	assert.Eventually(t, func() bool { return dialer.count() >= 2 }, time.Second, time.Millisecond)
+
+	resp, err := pool.Forget("foo.rrd")
+	assert.NoError(t, err)
+	assert.Equal(t, "FORGET foo.rrd", resp.Message)
+}
+
+func TestPoolBatch(t *testing.T) {
+	dialer := &fakeDialer{}
+	pool, err := NewPool(2, 0, dialer.dial)
+	assert.NoError(t, err)
+	defer pool.Close()
+
+	resp, err := pool.Batch().Update("batch-0.rrd", "1:1").Update("batch-0.rrd", "2:1").Exec()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "errors", resp.Message)
+}
+
+func TestPoolListIter(t *testing.T) {
+	transport, _ := prepStreamTestData([]byte(testListResponse))
+	dialer := &fakeDialer{next: []RRDIO{transport}}
+	pool, err := NewPool(1, 0, dialer.dial)
+	assert.NoError(t, err)
+	defer pool.Close()
+
+	it, err := pool.ListIter("/tmp", false)
+	assert.NoError(t, err)
+
+	// The only connection is out of the pool while the iterator is open.
+	assert.Len(t, pool.idle, 0)
+	assert.NoError(t, it.Close())
+	assert.Len(t, pool.idle, 1)
+}
+
+func TestPoolNowString(t *testing.T) {
+	pool, err := NewPool(2, 0, func() (*Rrdcached, error) {
+		r := &Rrdcached{Rrdio: &fakeEchoTransport{}}
+		WithTimestampPrecision(3)(r)
+		return r, nil
+	})
+	assert.NoError(t, err)
+	defer pool.Close()
+
+	assert.Regexp(t, `^\d+\.\d{3}$`, pool.NowString())
+}
+
+func TestPoolClosed(t *testing.T) {
+	dialer := &fakeDialer{}
+	pool, err := NewPool(2, time.Millisecond, dialer.dial)
+	assert.NoError(t, err)
+
+	assert.NoError(t, pool.Close())
+
+	_, err = pool.Flush("foo.rrd")
+	assert.IsType(t, &ConnectionError{}, err)
+}
+
+func TestPoolWithSuspendedSizeOne(t *testing.T) {
+	dialer := &fakeDialer{}
+	pool, err := NewPool(1, 0, dialer.dial)
+	assert.NoError(t, err)
+	defer pool.Close()
+
+	// fn uses the pool, so it would wait forever if the only connection were held for it.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var flushed *Response
+	err = pool.WithSuspendedContext(ctx, []string{"foo.rrd"}, func() error {
+		var err error
+		flushed, err = pool.FlushContext(ctx, "foo.rrd")
+		return err
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "FLUSH foo.rrd", flushed.Message)
+	assert.Len(t, pool.idle, 1)
+}
+
+func TestPoolUpdateAsyncReplacesBrokenConnection(t *testing.T) {
+	socket := startFakeDaemon(t, hangUpFirst("0 errors, enqueued 1 value(s).\n"))
+	pool, err := NewPool(1, 0, func() (*Rrdcached, error) { return ConnectToSocket(socket) })
+	assert.NoError(t, err)
+	defer pool.Close()
+
+	_, err = pool.UpdateAsync("foo.rrd", "1438354679:1").Wait()
+	assert.IsType(t, &ConnectionError{}, err)
+
+	// The connection stayed out of the pool until its reply failed, and was then redialed.
+	resp, err := pool.Update("foo.rrd", "1438354679:1")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, resp.Status)
+}
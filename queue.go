@@ -0,0 +1,40 @@
+package rrdcached
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// QueueEntry is a file waiting in the daemon's write queue.
+type QueueEntry struct {
+	Filename       string
+	PendingUpdates int
+}
+
+// Queue lists the files queued for writing, in the order the write threads will reach them.
+func (r *Rrdcached) Queue() ([]QueueEntry, error) {
+	return r.QueueContext(context.Background())
+}
+
+func (r *Rrdcached) QueueContext(ctx context.Context) ([]QueueEntry, error) {
+	resp, err := r.exec(ctx, "QUEUE")
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []QueueEntry
+	for _, line := range resp.Lines() {
+		field := strings.SplitN(line, " ", 2)
+		if len(field) != 2 {
+			return nil, fmt.Errorf("QUEUE returned malformed line %q", line)
+		}
+		pending, err := strconv.Atoi(field[0])
+		if err != nil {
+			return nil, fmt.Errorf("QUEUE returned malformed line %q: %v", line, err)
+		}
+		entries = append(entries, QueueEntry{Filename: field[1], PendingUpdates: pending})
+	}
+	return entries, nil
+}
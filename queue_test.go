@@ -0,0 +1,46 @@
+package rrdcached
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueue(t *testing.T) {
+	expected, fakeDriver := prepTestData(
+		"QUEUE\n",
+		"2 in queue\n4 /tmp/foo.rrd\n1 /tmp/bar baz.rrd\n",
+	)
+
+	entries, err := fakeDriver.Queue()
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, fakeDriver.Rrdio)
+	assert.Equal(t, []QueueEntry{
+		{Filename: "/tmp/foo.rrd", PendingUpdates: 4},
+		{Filename: "/tmp/bar baz.rrd", PendingUpdates: 1},
+	}, entries)
+}
+
+func TestQueueEmpty(t *testing.T) {
+	_, fakeDriver := prepTestData(
+		"QUEUE\n",
+		"0 in queue",
+	)
+
+	entries, err := fakeDriver.Queue()
+
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestQueueMalformed(t *testing.T) {
+	_, fakeDriver := prepTestData(
+		"QUEUE\n",
+		"1 in queue\nfoo /tmp/foo.rrd\n",
+	)
+
+	_, err := fakeDriver.Queue()
+
+	assert.Error(t, err)
+}
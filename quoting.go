@@ -0,0 +1,76 @@
+package rrdcached
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dreadpirateshawn/rrdcached/internal/protocol"
+)
+
+var (
+	errEmptyFilename    = errors.New("empty filename")
+	errControlCharacter = errors.New("contains a control character")
+)
+
+// UnsafeArgumentError is returned, without anything being sent, for an argument that would
+// corrupt the command line, such as a filename containing a newline.
+type UnsafeArgumentError struct {
+	Command  string
+	Argument string
+	Err      error
+}
+
+func (f *UnsafeArgumentError) Error() string {
+	return fmt.Sprintf("%s argument %q: %v", f.Command, f.Argument, f.Err)
+}
+
+func (f *UnsafeArgumentError) Unwrap() error {
+	return f.Err
+}
+
+// commandLine formats a command for the daemon, without the terminating newline.
+//
+// rrdcached reads one command per line and splits it at every space, taking the character
+// after a backslash literally. The filename is escaped that way, as rrd_client does, so that
+// it may contain spaces. In the other arguments only backslashes are escaped, since some,
+// like the definitions given to CREATE, are several fields joined by spaces. No argument may
+// contain a control character: a newline would end the command and start another.
+func commandLine(command string, args []string) (string, error) {
+	filename := filenameIndex(command, args)
+
+	fields := make([]string, 0, len(args)+1)
+	fields = append(fields, command)
+	for i, arg := range args {
+		err := checkArgument(arg, i == filename)
+		if err != nil {
+			return "", &UnsafeArgumentError{Command: command, Argument: arg, Err: err}
+		}
+		if i == filename {
+			arg = filenameEscaper.Replace(arg)
+		} else {
+			arg = strings.ReplaceAll(arg, `\`, `\\`)
+		}
+		fields = append(fields, arg)
+	}
+	return strings.Join(fields, " "), nil
+}
+
+func checkArgument(arg string, filename bool) error {
+	if filename && arg == "" {
+		return errEmptyFilename
+	}
+	for _, c := range arg {
+		if c < ' ' || c == 0x7f {
+			return errControlCharacter
+		}
+	}
+	return nil
+}
+
+var filenameEscaper = strings.NewReplacer(`\`, `\\`, " ", `\ `)
+
+// splitCommandLine splits a line formatted by commandLine back into its fields, as rrdcached does.
+func splitCommandLine(line string) []string {
+	return protocol.Fields(line)
+}
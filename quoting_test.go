@@ -0,0 +1,136 @@
+package rrdcached
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateFilenameWithSpaces(t *testing.T) {
+	expected, fakeDriver := prepTestData(
+		`UPDATE /tmp/my\ rrds/foo\\bar.rrd 1438354679:10`+"\n",
+		"0 errors, enqueued 1 value(s).",
+	)
+
+	_, err := fakeDriver.Update(`/tmp/my rrds/foo\bar.rrd`, "1438354679:10")
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, fakeDriver.Rrdio)
+}
+
+func TestListPathWithSpaces(t *testing.T) {
+	expected, fakeDriver := prepTestData(`LIST RECURSIVE /tmp/my\ rrds`+"\n", "0 RRDs")
+
+	_, err := fakeDriver.List("/tmp/my rrds", true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, fakeDriver.Rrdio)
+}
+
+func TestUnsafeArguments(t *testing.T) {
+	cases := []struct {
+		filename string
+		value    string
+		message  string
+	}{
+		{"foo.rrd\nFLUSHALL", "1438354679:10", `UPDATE argument "foo.rrd\nFLUSHALL": contains a control character`},
+		{"foo.rrd", "1438354679:10\rFLUSHALL", `UPDATE argument "1438354679:10\rFLUSHALL": contains a control character`},
+		{"foo\x00.rrd", "1438354679:10", `UPDATE argument "foo\x00.rrd": contains a control character`},
+		{"", "1438354679:10", `UPDATE argument "": empty filename`},
+	}
+
+	for _, c := range cases {
+		_, fakeDriver := prepTestData("", "0 errors, enqueued 1 value(s).")
+
+		_, err := fakeDriver.Update(c.filename, c.value)
+
+		assert.IsType(t, &UnsafeArgumentError{}, err)
+		assert.EqualError(t, err, c.message)
+		assert.Equal(t, "", fakeDriver.Rrdio.(*fakeDataTransport).written)
+	}
+}
+
+func TestBatchUnsafeArguments(t *testing.T) {
+	transport, fakeDriver := prepSequenceTestData(
+		"0 Go ahead.  End with dot '.' on its own line.",
+		"0 errors",
+	)
+
+	_, err := fakeDriver.Batch().
+		Update("foo.rrd", "1438354679:10").
+		Flush("foo.rrd\n.\nFLUSHALL").
+		Exec()
+
+	assert.IsType(t, &UnsafeArgumentError{}, err)
+	assert.Empty(t, transport.written)
+}
+
+func TestBatchCommandErrorFilenameWithSpaces(t *testing.T) {
+	err := &BatchCommandError{Line: 1, Command: `UPDATE /tmp/my\ rrds/foo.rrd 1438354679:10`, Message: "No such file: /tmp/my rrds/foo.rrd"}
+
+	var serverErr *ServerError
+	assert.True(t, errors.As(err, &serverErr))
+	assert.Equal(t, "/tmp/my rrds/foo.rrd", serverErr.Filename)
+}
+
+// FuzzCommandLine checks that whatever the filename and value, the daemon either reads
+// exactly one command with those arguments, or nothing is sent.
+func FuzzCommandLine(f *testing.F) {
+	f.Add("foo.rrd", "1438354679:10")
+	f.Add("/tmp/my rrds/foo.rrd", "N:1:2")
+	f.Add(`C:\rrd\foo bar.rrd`, `1438354679:\`)
+	f.Add("foo.rrd\nFLUSHALL", "1438354679:10")
+	f.Add("foo.rrd", "1438354679:10\r\nQUIT")
+	f.Add(" ", "")
+	f.Add(`\`, `\ `)
+
+	f.Fuzz(func(t *testing.T, filename string, value string) {
+		line, err := commandLine("UPDATE", []string{filename, value})
+		if err != nil {
+			if _, ok := err.(*UnsafeArgumentError); !ok {
+				t.Fatalf("unexpected error %T: %v", err, err)
+			}
+			return
+		}
+
+		if strings.ContainsAny(line, "\r\n\x00") {
+			t.Fatalf("command line %q would be split", line)
+		}
+		fields := splitCommandLine(line)
+		if fields[0] != "UPDATE" || fields[1] != filename {
+			t.Fatalf("command line %q is read as %q", line, fields)
+		}
+		if got := strings.Join(fields[2:], " "); got != value {
+			t.Fatalf("command line %q has values %q, expected %q", line, got, value)
+		}
+	})
+}
+
+// FuzzBatchFilename checks that a filename cannot add lines to a batch.
+func FuzzBatchFilename(f *testing.F) {
+	f.Add("foo.rrd")
+	f.Add("foo.rrd\n.\nFLUSHALL")
+	f.Add("my rrds/foo.rrd")
+
+	f.Fuzz(func(t *testing.T, filename string) {
+		transport, fakeDriver := prepSequenceTestData(
+			"0 Go ahead.  End with dot '.' on its own line.",
+			"0 errors",
+		)
+
+		_, err := fakeDriver.Batch().Update(filename, "1438354679:10").Flush(filename).Exec()
+		if err != nil {
+			if _, ok := err.(*UnsafeArgumentError); !ok {
+				t.Fatalf("unexpected error %T: %v", err, err)
+			}
+			return
+		}
+
+		lines := strings.Split(strings.TrimSuffix(transport.written[1], "\n"), "\n")
+		if len(lines) != 3 || lines[2] != "." {
+			t.Fatalf("batch of 2 commands was sent as %q", lines)
+		}
+	})
+}
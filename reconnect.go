@@ -0,0 +1,180 @@
+package rrdcached
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// ReconnectPolicy controls how a client recovers from a broken connection.
+//
+// After a ConnectionError the connection is redialed, waiting between failed
+// attempts with exponential backoff. Once reconnected, commands that are safe
+// to repeat (FLUSH, PENDING, LAST, INFO and the other read-only commands) are
+// sent again. UPDATE may already have reached the daemon before the connection
+// broke, and resending it can fail with an illegal update, so it is only
+// repeated UpdateRetries times. CREATE, FORGET and BATCH are never repeated;
+// a FORGET that did reach the daemon would fail with "No such file" if resent.
+//
+// A command that is not repeated leaves the client disconnected, and the next
+// command reconnects before it is sent. Other commands may use the client while
+// a reconnect waits out its backoff.
+type ReconnectPolicy struct {
+	// MaxAttempts limits the dials per reconnect, and the repetitions of a single command.
+	// Values below 1 mean 1.
+	MaxAttempts int
+
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+
+	// Jitter spreads each backoff by up to this fraction in either direction, so
+	// that clients of a restarted daemon do not all redial at once.
+	Jitter float64
+
+	UpdateRetries int
+}
+
+var DefaultReconnectPolicy = ReconnectPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+	UpdateRetries:  0,
+}
+
+var idempotentCommands = map[string]bool{
+	"FETCH":    true,
+	"FETCHBIN": true,
+	"FIRST":    true,
+	"FLUSH":    true,
+	"FLUSHALL": true,
+	"HELP":     true,
+	"INFO":     true,
+	"LAST":     true,
+	"LIST":     true,
+	"PENDING":  true,
+	"QUEUE":    true,
+	"STATS":    true,
+}
+
+func (policy *ReconnectPolicy) maxAttempts() int {
+	if policy.MaxAttempts < 1 {
+		return 1
+	}
+	return policy.MaxAttempts
+}
+
+func (policy *ReconnectPolicy) retries(command string) int {
+	if command == "UPDATE" {
+		return policy.UpdateRetries
+	}
+	if idempotentCommands[command] {
+		return policy.maxAttempts()
+	}
+	return 0
+}
+
+func (policy *ReconnectPolicy) backoff(delay time.Duration) time.Duration {
+	if policy.Jitter > 0 {
+		delay = time.Duration(float64(delay) * (1 + policy.Jitter*(2*rand.Float64()-1)))
+	}
+	return delay
+}
+
+func (policy *ReconnectPolicy) nextBackoff(delay time.Duration) time.Duration {
+	if policy.Multiplier > 1 {
+		delay = time.Duration(float64(delay) * policy.Multiplier)
+	}
+	if policy.MaxBackoff > 0 && delay > policy.MaxBackoff {
+		delay = policy.MaxBackoff
+	}
+	return delay
+}
+
+// withReconnect runs attempt bounded by ctx, reconnecting if it fails with a ConnectionError
+// and repeating it as far as the policy allows for command. The caller holds the connection.
+func (r *Rrdcached) withReconnect(ctx context.Context, command string, attempt func() error) (err error) {
+	r.sent = sentCommand{command: command, start: time.Now()}
+	defer func() {
+		if err != nil {
+			r.logFailure(err)
+		}
+	}()
+
+	for retry := 0; ; retry++ {
+		if r.Conn == nil && r.Reconnect != nil {
+			reconnectErr := r.reconnect(ctx)
+			if reconnectErr != nil {
+				return reconnectErr
+			}
+		}
+
+		err := r.withContext(ctx, attempt)
+		if _, broken := err.(*ConnectionError); !broken || r.Reconnect == nil {
+			return err
+		}
+
+		r.disconnect()
+		if retry >= r.Reconnect.retries(command) {
+			return err
+		}
+		r.log(LevelInfo, "reconnecting", "command", command, "error", err)
+	}
+}
+
+// disconnect closes a broken connection, so that the next command reconnects first.
+func (r *Rrdcached) disconnect() {
+	if r.Conn != nil {
+		r.Conn.Close()
+	}
+	r.Conn = nil
+	r.capabilities = nil
+	r.probed = false
+}
+
+// reconnect dials until it succeeds or the policy gives up. The connection is released while
+// waiting between dials, and if another command reconnects in the meantime, its connection is used.
+func (r *Rrdcached) reconnect(ctx context.Context) error {
+	policy := r.Reconnect
+
+	delay := policy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		conn, err := r.dial(ctx)
+		if err == nil {
+			r.Conn = conn
+			return nil
+		}
+		if _, timedOut := err.(*TimeoutError); timedOut {
+			return err
+		}
+		if attempt >= policy.maxAttempts() {
+			if _, failed := err.(*ConnectionError); failed {
+				return err
+			}
+			return checkError(err)
+		}
+
+		sent := r.sent
+		r.mu.Unlock()
+		var waitErr error
+		timer := time.NewTimer(policy.backoff(delay))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			waitErr = &TimeoutError{ctx.Err()}
+		case <-timer.C:
+		}
+		r.lock()
+		r.sent = sent
+
+		if waitErr != nil {
+			return waitErr
+		}
+		if r.Conn != nil {
+			return nil
+		}
+		delay = policy.nextBackoff(delay)
+	}
+}
@@ -0,0 +1,313 @@
+package rrdcached
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// startFakeDaemon listens on a unix socket and hands the nth accepted
+// connection (counting from 0) to serve, standing in for a daemon that restarts.
+func startFakeDaemon(t *testing.T, serve func(n int, conn net.Conn, in *bufio.Reader)) string {
+	socket := filepath.Join(t.TempDir(), "rrdcached.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var conns []net.Conn
+	closed := false
+	t.Cleanup(func() {
+		listener.Close()
+		mu.Lock()
+		closed = true
+		for _, conn := range conns {
+			conn.Close()
+		}
+		mu.Unlock()
+		wg.Wait()
+	})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for n := 0; ; n++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			if closed {
+				mu.Unlock()
+				conn.Close()
+				return
+			}
+			conns = append(conns, conn)
+			mu.Unlock()
+			wg.Add(1)
+			go func(n int) {
+				defer wg.Done()
+				defer conn.Close()
+				serve(n, conn, bufio.NewReader(conn))
+			}(n)
+		}
+	}()
+
+	return socket
+}
+
+// hangUpFirst drops the first connection after reading one command, and answers on later ones.
+func hangUpFirst(reply string) func(n int, conn net.Conn, in *bufio.Reader) {
+	return func(n int, conn net.Conn, in *bufio.Reader) {
+		for {
+			_, err := in.ReadString('\n')
+			if err != nil || n == 0 {
+				return
+			}
+			conn.Write([]byte(reply))
+		}
+	}
+}
+
+var testReconnectPolicy = ReconnectPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     5 * time.Millisecond,
+	Multiplier:     2,
+	Jitter:         0.5,
+}
+
+func TestReconnectRetriesIdempotentCommand(t *testing.T) {
+	socket := startFakeDaemon(t, hangUpFirst("0 Successfully flushed /tmp/foo.rrd.\n"))
+	driver, err := ConnectToSocket(socket)
+	assert.NoError(t, err)
+	policy := testReconnectPolicy
+	driver.Reconnect = &policy
+
+	resp, err := driver.Flush("foo.rrd")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, resp.Status)
+}
+
+func TestReconnectDoesNotRetryUpdate(t *testing.T) {
+	socket := startFakeDaemon(t, hangUpFirst("0 errors, enqueued 1 value(s).\n"))
+	driver, err := ConnectToSocket(socket)
+	assert.NoError(t, err)
+	policy := testReconnectPolicy
+	driver.Reconnect = &policy
+
+	_, err = driver.Update("foo.rrd", "1438354679:10")
+	assert.IsType(t, &ConnectionError{}, err)
+
+	// The connection was replaced, so the caller's own retry succeeds.
+	resp, err := driver.Update("foo.rrd", "1438354679:10")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, resp.Status)
+}
+
+func TestReconnectRetriesUpdateWhenAllowed(t *testing.T) {
+	socket := startFakeDaemon(t, hangUpFirst("0 errors, enqueued 1 value(s).\n"))
+	driver, err := ConnectToSocket(socket)
+	assert.NoError(t, err)
+	policy := testReconnectPolicy
+	policy.UpdateRetries = 1
+	driver.Reconnect = &policy
+
+	resp, err := driver.Update("foo.rrd", "1438354679:10")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, resp.Status)
+}
+
+func TestReconnectGivesUp(t *testing.T) {
+	var mu sync.Mutex
+	accepted := 0
+	socket := startFakeDaemon(t, func(n int, conn net.Conn, in *bufio.Reader) {
+		mu.Lock()
+		accepted++
+		mu.Unlock()
+		in.ReadString('\n')
+	})
+	driver, err := ConnectToSocket(socket)
+	assert.NoError(t, err)
+	policy := testReconnectPolicy
+	driver.Reconnect = &policy
+
+	_, err = driver.Last("foo.rrd")
+
+	assert.IsType(t, &ConnectionError{}, err)
+	// Nothing is dialed after the last attempt; the next command reconnects.
+	assert.Nil(t, driver.Conn)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 1+policy.MaxAttempts, accepted)
+}
+
+func TestReconnectDoesNotRetryForget(t *testing.T) {
+	socket := startFakeDaemon(t, hangUpFirst("0 Gone\n"))
+	driver, err := ConnectToSocket(socket)
+	assert.NoError(t, err)
+	policy := testReconnectPolicy
+	driver.Reconnect = &policy
+
+	_, err = driver.Forget("foo.rrd")
+	assert.IsType(t, &ConnectionError{}, err)
+	assert.Nil(t, driver.Conn)
+
+	resp, err := driver.Forget("foo.rrd")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, resp.Status)
+}
+
+func TestReconnectBackoffReleasesConnection(t *testing.T) {
+	socket := startFakeDaemon(t, hangUpFirst("0 Successfully flushed /tmp/foo.rrd.\n"))
+	var dials int32
+	dialer := fakeDialerFunc(func(ctx context.Context, network string, address string) (net.Conn, error) {
+		if atomic.AddInt32(&dials, 1) > 1 {
+			return nil, errors.New("dial unix " + address + ": connect: connection refused")
+		}
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, address)
+	})
+	driver, err := New(socket, WithDialer(dialer), WithReconnect(ReconnectPolicy{MaxAttempts: 2, InitialBackoff: time.Second}))
+	assert.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		_, err := driver.Flush("foo.rrd")
+		done <- err
+	}()
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&dials) >= 2 }, time.Second, time.Millisecond)
+
+	// The first redial failed, and the flush is waiting to dial again.
+	locked := make(chan struct{})
+	go func() {
+		driver.mu.Lock()
+		driver.mu.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(500 * time.Millisecond):
+		t.Error("the connection is held during the backoff")
+	}
+
+	assert.IsType(t, &ConnectionError{}, <-done)
+}
+
+func TestReconnectDialFailure(t *testing.T) {
+	driver, _ := ConnectToSocket(filepath.Join(t.TempDir(), "missing.sock"))
+	policy := testReconnectPolicy
+	driver.Reconnect = &policy
+
+	_, err := driver.Flush("foo.rrd")
+
+	assert.IsType(t, &ConnectionError{}, err)
+}
+
+// blackholeAfterFirst dials socket once, then waits for every later dial to time out.
+func blackholeAfterFirst(dials *int32) fakeDialerFunc {
+	return func(ctx context.Context, network string, address string) (net.Conn, error) {
+		if atomic.AddInt32(dials, 1) > 1 {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, address)
+	}
+}
+
+func TestReconnectDialTimeout(t *testing.T) {
+	socket := startFakeDaemon(t, hangUpFirst("0 Successfully flushed /tmp/foo.rrd.\n"))
+	var dials int32
+	driver, err := New(socket, WithDialer(blackholeAfterFirst(&dials)),
+		WithDialTimeout(10*time.Millisecond), WithReconnect(testReconnectPolicy))
+	assert.NoError(t, err)
+
+	_, err = driver.Flush("foo.rrd")
+
+	assert.IsType(t, &ConnectionError{}, err)
+	assert.Equal(t, int32(1+testReconnectPolicy.MaxAttempts), atomic.LoadInt32(&dials))
+}
+
+func TestReconnectDialTimeoutWithContext(t *testing.T) {
+	socket := startFakeDaemon(t, hangUpFirst("0 Successfully flushed /tmp/foo.rrd.\n"))
+	var dials int32
+	driver, err := New(socket, WithDialer(blackholeAfterFirst(&dials)),
+		WithDialTimeout(time.Minute), WithReconnect(testReconnectPolicy))
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = driver.FlushContext(ctx, "foo.rrd")
+
+	assert.IsType(t, &TimeoutError{}, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&dials))
+}
+
+func TestReconnectAfterBrokenBatch(t *testing.T) {
+	socket := startFakeDaemon(t, func(n int, conn net.Conn, in *bufio.Reader) {
+		for {
+			line, err := in.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch {
+			case line == "BATCH\n":
+				conn.Write([]byte("0 Go ahead.  End with dot '.' on its own line.\n"))
+			case n == 0:
+				// Hang up in the middle of the payload.
+				return
+			default:
+				conn.Write([]byte("0 errors, enqueued 1 value(s).\n"))
+			}
+		}
+	})
+	driver, err := ConnectToSocket(socket)
+	assert.NoError(t, err)
+	policy := testReconnectPolicy
+	driver.Reconnect = &policy
+
+	_, err = driver.Batch().Update("foo.rrd", "1438354679:10").Exec()
+	assert.IsType(t, &ConnectionError{}, err)
+	assert.Nil(t, driver.Conn)
+
+	// UPDATE is not retried, so it only succeeds if it is sent on a new connection.
+	resp, err := driver.Update("foo.rrd", "1438354680:10")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, resp.Status)
+}
+
+func TestReconnectDisabled(t *testing.T) {
+	socket := startFakeDaemon(t, hangUpFirst("0 Successfully flushed /tmp/foo.rrd.\n"))
+	driver, err := ConnectToSocket(socket)
+	assert.NoError(t, err)
+
+	_, err = driver.Flush("foo.rrd")
+	assert.IsType(t, &ConnectionError{}, err)
+
+	_, err = driver.Flush("foo.rrd")
+	assert.IsType(t, &ConnectionError{}, err)
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	policy := ReconnectPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 3, Jitter: 0.1}
+
+	assert.Equal(t, 300*time.Millisecond, policy.nextBackoff(100*time.Millisecond))
+	assert.Equal(t, time.Second, policy.nextBackoff(900*time.Millisecond))
+	for i := 0; i < 100; i++ {
+		delay := policy.backoff(100 * time.Millisecond)
+		assert.True(t, delay >= 90*time.Millisecond && delay <= 110*time.Millisecond, "%v out of range", delay)
+	}
+}
@@ -1,19 +1,32 @@
 package rrdcached
 
 import (
+	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"reflect"
-	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
-
-	"github.com/golang/glog"
 )
 
+// Rrdcached is a client for a single connection to rrdcached.
+//
+// It is safe for concurrent use by multiple goroutines. Each command holds the
+// connection from the moment it is written until its reply has been read, so
+// commands from different goroutines are serialized rather than interleaved,
+// and every caller receives the reply to its own command. Batch.Exec holds it
+// across both of its round trips, and a ListIterator until it is exhausted or
+// closed. WithSuspended is a sequence of separate commands, so other goroutines
+// may use the connection while its callback runs. Commands sent by UpdateAsync
+// only hold the connection while being written; other commands wait until their
+// replies have all been read.
+//
+// The exported fields configure the client and must not be changed while it is in use.
 type Rrdcached struct {
 	Protocol string
 	Socket   string
@@ -21,44 +34,119 @@ type Rrdcached struct {
 	Port     int64
 	Conn     net.Conn
 	Rrdio    RRDIO
+
+	// Reconnect, if set, redials after a ConnectionError and retries commands that are safe to repeat.
+	Reconnect *ReconnectPolicy
+
+	// Set by the options passed to New.
+	dialer       Dialer
+	dialTimeout  time.Duration
+	keepAlive    time.Duration
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	logger       Logger
+	precision    int
+	interceptors []Interceptor
+	guard        *TimestampGuard
+
+	mu           sync.Mutex
+	reader       *bufio.Reader
+	readerConn   net.Conn
+	capabilities *Capabilities
+	probed       bool
+	sent         sentCommand
+
+	// asyncMu guards the futures of commands sent by UpdateAsync, oldest first.
+	// drained is closed once their reader has read the last reply.
+	asyncMu  sync.Mutex
+	inflight []*Future
+	drained  chan struct{}
+
+	// deadlineMu orders the deadlines set by read and write timeouts against context cancellation.
+	deadlineMu sync.Mutex
+	deadline   time.Time
+	cancelled  bool
 }
 
 func ConnectToSocket(socket string) (*Rrdcached, error) {
+	return ConnectToSocketContext(context.Background(), socket)
+}
+
+// ConnectToSocketContext is ConnectToSocket, giving up on the dial once ctx is done.
+func ConnectToSocketContext(ctx context.Context, socket string) (*Rrdcached, error) {
 	driver := &Rrdcached{
 		Protocol: "unix",
 		Socket:   socket,
 		Rrdio:    &dataTransport{},
 	}
-	err := driver.connect()
+	err := driver.connect(ctx)
 	return driver, err
 }
 
 func ConnectToIP(ip string, port int64) (*Rrdcached, error) {
+	return ConnectToIPContext(context.Background(), ip, port)
+}
+
+// ConnectToIPContext is ConnectToIP, giving up on the dial once ctx is done.
+func ConnectToIPContext(ctx context.Context, ip string, port int64) (*Rrdcached, error) {
 	driver := &Rrdcached{
 		Protocol: "tcp",
 		Ip:       ip,
 		Port:     port,
 		Rrdio:    &dataTransport{},
 	}
-	driver.connect()
-	err := driver.connect()
+	err := driver.connect(ctx)
 	return driver, err
 }
 
-func (r *Rrdcached) connect() error {
+func (r *Rrdcached) connect(ctx context.Context) error {
+	conn, err := r.dial(ctx)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Conn = conn
+	r.capabilities = nil
+	r.probed = false
+	return err
+}
+
+func (r *Rrdcached) dial(ctx context.Context) (net.Conn, error) {
 	var target string
 
 	if r.Protocol == "unix" {
 		target = r.Socket
 	} else if r.Protocol == "tcp" {
-		target = r.Ip + ":" + strconv.FormatInt(r.Port, 10)
+		target = net.JoinHostPort(r.Ip, strconv.FormatInt(r.Port, 10))
 	} else {
 		panic(fmt.Sprintf("Protocol %v is not recognized: %+v", r.Protocol, r))
 	}
 
-	conn, err := net.Dial(r.Protocol, target)
-	r.Conn = conn
-	return err
+	dialer := r.dialer
+	if dialer == nil {
+		dialer = &net.Dialer{KeepAlive: r.keepAlive}
+	}
+	dialCtx := ctx
+	if r.dialTimeout > 0 {
+		var cancel context.CancelFunc
+		dialCtx, cancel = context.WithTimeout(ctx, r.dialTimeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	conn, err := dialer.DialContext(dialCtx, r.Protocol, target)
+	if err != nil {
+		r.log(LevelError, "connect failed", "address", target, "error", err, "latency", time.Since(start))
+		if ctx.Err() != nil {
+			return nil, &TimeoutError{ctx.Err()}
+		}
+		if dialCtx.Err() != nil {
+			// Only the dial timed out, which is worth another attempt under WithReconnect.
+			return nil, &ConnectionError{err}
+		}
+		return nil, err
+	}
+	r.log(LevelInfo, "connected", "address", target, "latency", time.Since(start))
+	return conn, nil
 }
 
 type Stats struct {
@@ -76,62 +164,18 @@ type Stats struct {
 
 // ----------------------------------------------------------
 
-type PanicError struct {
-	Err error
-}
-
-func (f *PanicError) Error() string {
-	return f.Err.Error()
-}
-
-type ConnectionError struct {
-	Err error
-}
-
-func (f *ConnectionError) Error() string {
-	return f.Err.Error()
-}
-
-type UnknownCommandError struct {
-	Err error
-}
-
-func (f *UnknownCommandError) Error() string {
-	return f.Err.Error()
-}
-
-type FileDoesNotExistError struct {
-	Err error
-}
-
-func (f *FileDoesNotExistError) Error() string {
-	return f.Err.Error()
-}
-
-type UnrecognizedArgumentError struct {
-	Err error
-}
-
-func (f *UnrecognizedArgumentError) Error() string {
-	return f.Err.Error()
-}
-
-func (f *UnrecognizedArgumentError) BadArgument() string {
-	re := regexp.MustCompile(`can't parse argument '(.+)'`)
-	matches := re.FindStringSubmatch(f.Error())
-	if matches != nil {
-		return matches[1]
-	} else {
-		return ""
-	}
-}
-
 func checkError(err error) error {
 	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return &TimeoutError{err}
+		}
 		switch {
-		case strings.HasPrefix(err.Error(), "dial tcp:"), strings.HasPrefix(err.Error(), "dial unix "):
+		case strings.HasPrefix(err.Error(), "dial tcp"), strings.HasPrefix(err.Error(), "dial unix "):
 			return &ConnectionError{err}
-		case strings.Contains(err.Error(), " broken pipe"):
+		case strings.Contains(err.Error(), " broken pipe"), strings.Contains(err.Error(), " connection reset by peer"):
+			return &ConnectionError{err}
+		case err == io.EOF, err == io.ErrUnexpectedEOF, errors.Is(err, net.ErrClosed):
+			// The daemon went away, e.g. it was restarted.
 			return &ConnectionError{err}
 		}
 		return &PanicError{err}
@@ -180,52 +224,88 @@ type RRDIO interface {
 	WriteData(conn net.Conn, data string) error
 }
 
+// RRDStreamIO is implemented by transports that can read a reply piece by piece
+// instead of as a single string, as replies carrying binary data require.
+type RRDStreamIO interface {
+	ReadLine(r io.Reader) (string, error)
+	ReadFull(r io.Reader, buf []byte) error
+}
+
 type dataTransport struct{}
 
+// ReadData reads the status line, then exactly as many lines as it announces,
+// so that nothing belonging to the next reply is consumed. r should be the
+// persistent reader of the connection, since anything read ahead of the reply
+// is kept in its buffer.
 func (rrdio dataTransport) ReadData(r io.Reader) (string, error) {
-	data := ""
-
 	if r == nil {
 		return "", &ConnectionError{fmt.Errorf("RRDCacheD is not connected, cannot read data.")}
 	}
+	in := bufferedReader(r)
 
-	for {
-		buf := make([]byte, 1024)
-		n, err := r.Read(buf[:])
+	var data strings.Builder
+	err := readLineInto(in, &data)
+	if err != nil {
+		return "", checkError(err)
+	}
+
+	// A status that is not a positive number has no lines following it.
+	count, err := strconv.ParseUint(strings.SplitN(data.String(), " ", 2)[0], 10, 64)
+	if err != nil {
+		return data.String(), nil
+	}
+
+	for i := uint64(0); i < count; i++ {
+		err = readLineInto(in, &data)
 		if err != nil {
 			return "", checkError(err)
 		}
-		data += string(buf[0:n])
-
-		// If response starts with a positive number,
-		// that indicates how many additional lines are expected.
-		// Otherwise, go ahead and break.
-		check := strings.Split(data, " ")
-		if len(check) > 1 {
-			status, err := strconv.ParseUint(check[0], 10, 64)
-
-			// Not a number.
-			if err != nil {
-				break
-			}
-			// Not a positive number.
-			if status <= 0 {
-				break
-			}
-			// More lines are expected, do we have them all yet?
-			lines := strings.Split(data, "\n")
-			if uint64(len(lines)) >= (status + 1) {
-				break
-			}
+	}
+
+	return data.String(), nil
+}
+
+// readLineInto appends the next line to data, without allocating a string for it.
+func readLineInto(in *bufio.Reader, data *strings.Builder) error {
+	for {
+		chunk, err := in.ReadSlice('\n')
+		data.Write(chunk)
+		if err != bufio.ErrBufferFull {
+			return err
 		}
 	}
+}
 
-	return data, nil
+func (rrdio dataTransport) ReadLine(r io.Reader) (string, error) {
+	if r == nil {
+		return "", &ConnectionError{fmt.Errorf("RRDCacheD is not connected, cannot read data.")}
+	}
+
+	line, err := bufferedReader(r).ReadString('\n')
+	if err != nil {
+		return "", checkError(err)
+	}
+	return line, nil
 }
 
-func (rrdio dataTransport) WriteData(conn net.Conn, data string) error {
-	glog.V(10).Infof("========== %v", data)
+func (rrdio dataTransport) ReadFull(r io.Reader, buf []byte) error {
+	if r == nil {
+		return &ConnectionError{fmt.Errorf("RRDCacheD is not connected, cannot read data.")}
+	}
+
+	_, err := io.ReadFull(bufferedReader(r), buf)
+	return checkError(err)
+}
+
+// bufferedReader avoids wrapping the persistent reader handed out by Rrdcached a second time.
+func bufferedReader(r io.Reader) *bufio.Reader {
+	if br, ok := r.(*bufio.Reader); ok {
+		return br
+	}
+	return bufio.NewReader(r)
+}
 
+func (rrdio dataTransport) WriteData(conn net.Conn, data string) error {
 	if conn == nil {
 		return &ConnectionError{fmt.Errorf("RRDCacheD is not connected, cannot write data.")}
 	}
@@ -235,10 +315,29 @@ func (rrdio dataTransport) WriteData(conn net.Conn, data string) error {
 }
 
 func (r *Rrdcached) read() (string, error) {
-	return r.Rrdio.ReadData(r.Conn)
+	data, err := r.Rrdio.ReadData(r.stream())
+	if err == nil {
+		r.logReply(data)
+	}
+	return data, err
+}
+
+// stream returns a reader over Conn that keeps its buffer between calls,
+// so bytes read ahead of one line are not lost to the next.
+func (r *Rrdcached) stream() io.Reader {
+	if r.Conn == nil {
+		return nil
+	}
+	r.armDeadline(r.readTimeout, net.Conn.SetReadDeadline)
+	if r.reader == nil || r.readerConn != r.Conn {
+		r.reader = bufio.NewReader(r.Conn)
+		r.readerConn = r.Conn
+	}
+	return r.reader
 }
 
 func (r *Rrdcached) write(data string) error {
+	r.armDeadline(r.writeTimeout, net.Conn.SetWriteDeadline)
 	return r.Rrdio.WriteData(r.Conn, data)
 }
 
@@ -248,29 +347,85 @@ type Response struct {
 	Raw     string
 }
 
+// Lines returns the non-empty lines that followed the status line.
+func (resp *Response) Lines() []string {
+	var lines []string
+	for _, line := range strings.Split(resp.Raw, "\n")[1:] {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// writeCommand sends one command line, failing fast if the daemon is known not to support it.
+// The first command on a connection that not every daemon supports probes the daemon first.
+func (r *Rrdcached) writeCommand(command string, args ...string) error {
+	if !baseCommands[command] {
+		err := r.probe()
+		if err != nil {
+			return err
+		}
+	}
+	err := r.checkSupported(command)
+	if err != nil {
+		return err
+	}
+	r.sent.filename = commandFilename(command, args)
+	r.log(LevelDebug, "command", "command", command, "filename", r.sent.filename)
+	line, err := commandLine(command, args)
+	if err != nil {
+		return err
+	}
+	return r.write(line + "\n")
+}
+
+// exec sends a command whose reply is a status line, plus as many lines as the status announces.
+func (r *Rrdcached) exec(ctx context.Context, command string, args ...string) (*Response, error) {
+	return r.invoke(ctx, command, args, func(ctx context.Context, command string, args []string) (resp *Response, err error) {
+		r.lock()
+		defer r.mu.Unlock()
+
+		err = r.withReconnect(ctx, command, func() error {
+			resp, err = r.roundTrip(command, args...)
+			return err
+		})
+		return resp, err
+	})
+}
+
+// roundTrip is exec for callers already holding the connection.
+func (r *Rrdcached) roundTrip(command string, args ...string) (*Response, error) {
+	err := r.writeCommand(command, args...)
+	if err != nil {
+		return nil, err
+	}
+	return r.checkResponse()
+}
+
 func (r *Rrdcached) checkResponse() (*Response, error) {
 	data, err := r.read()
 	if err != nil {
 		return nil, err
 	}
 
+	return parseResponse(r.sent, data)
+}
+
+// parseResponse reads the status line of a reply. The daemon's complaints are returned as
+// ServerError, naming the command they were sent for.
+func parseResponse(sent sentCommand, data string) (*Response, error) {
+	var err error
+
 	data = strings.TrimSpace(data)
-	glog.V(10).Infof(data)
 
 	lines := strings.SplitN(data, " ", 2)
 
 	status, _ := strconv.ParseInt(lines[0], 10, 0)
 
 	if int(status) == -1 {
-		err = errors.New(lines[1])
-		switch {
-		case strings.HasPrefix(lines[1], "Unknown command"):
-			err = &UnknownCommandError{err}
-		case strings.HasPrefix(lines[1], "No such file"):
-			err = &FileDoesNotExistError{err}
-		case strings.Contains(lines[1], "can't parse argument"):
-			err = &UnrecognizedArgumentError{err}
-		}
+		err = newServerError(sent, lines[1])
 	}
 
 	return &Response{
@@ -284,6 +439,15 @@ func NowString() string {
 	// rrdcached doesn't grok milliseconds before v1.4.5:
 	// https://lists.oetiker.ch/pipermail/rrd-users/2011-May/017816.html
 	precision := 0 // 3 is supported in newer versions
+	return nowString(precision)
+}
+
+// NowString is the package's NowString, with the precision set by WithTimestampPrecision.
+func (r *Rrdcached) NowString() string {
+	return nowString(r.precision)
+}
+
+func nowString(precision int) string {
 	ms := float64(time.Now().UnixNano()) / float64(time.Second)
 	return strconv.FormatFloat(ms, 'f', precision, 64)
 }
@@ -291,24 +455,61 @@ func NowString() string {
 // ----------------------------------------------------------
 
 func (r *Rrdcached) GetStats() (*Stats, error) {
-	writeErr := r.write("STATS\n")
-	if writeErr != nil {
-		return nil, writeErr
-	}
+	return r.GetStatsContext(context.Background())
+}
 
-	data, readErr := r.read()
-	return parseStats(data), readErr
+func (r *Rrdcached) GetStatsContext(ctx context.Context) (*Stats, error) {
+	resp, err := r.invoke(ctx, "STATS", nil, func(ctx context.Context, command string, args []string) (*Response, error) {
+		r.lock()
+		defer r.mu.Unlock()
+
+		var data string
+		err := r.withReconnect(ctx, command, func() error {
+			writeErr := r.writeCommand(command, args...)
+			if writeErr != nil {
+				return writeErr
+			}
+
+			var readErr error
+			data, readErr = r.read()
+			return readErr
+		})
+		if data == "" {
+			return nil, err
+		}
+		// Replies to STATS have never been checked for errors, only parsed.
+		resp, _ := parseResponse(r.sent, data)
+		return resp, err
+	})
+	if resp == nil {
+		return nil, err
+	}
+	return parseStats(resp.Raw), err
 }
 
 func (r *Rrdcached) Create(filename string, start int64, step int64, overwrite bool, ds []string, rra []string) (*Response, error) {
-	var params []string
+	return r.CreateContext(context.Background(), filename, start, step, overwrite, ds, rra)
+}
+
+func (r *Rrdcached) CreateContext(ctx context.Context, filename string, start int64, step int64, overwrite bool, ds []string, rra []string) (*Response, error) {
+	var capabilities *Capabilities
+	if !overwrite {
+		var err error
+		capabilities, err = r.probedCapabilities(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	params := []string{filename}
 	if start >= 0 {
 		params = append(params, fmt.Sprintf("-b %d", start))
 	}
 	if step >= 0 {
 		params = append(params, fmt.Sprintf("-s %d", step))
 	}
-	if !overwrite {
+	// Daemons that predate -O cannot refuse to overwrite, so it is left out rather than rejected.
+	if !overwrite && (capabilities == nil || capabilities.CreateNoOverwrite()) {
 		params = append(params, "-O")
 	}
 	if ds != nil {
@@ -318,69 +519,94 @@ func (r *Rrdcached) Create(filename string, start int64, step int64, overwrite b
 		params = append(params, strings.Join(rra, " "))
 	}
 
-	err := r.write("CREATE " + filename + " " + strings.Join(params, " ") + "\n")
-	if err != nil {
-		return nil, err
-	}
-	return r.checkResponse()
+	return r.exec(ctx, "CREATE", params...)
 }
 
 func (r *Rrdcached) Update(filename string, values ...string) (*Response, error) {
-	err := r.write("UPDATE " + filename + " " + strings.Join(values, " ") + "\n")
+	return r.UpdateContext(context.Background(), filename, values...)
+}
+
+func (r *Rrdcached) UpdateContext(ctx context.Context, filename string, values ...string) (*Response, error) {
+	values, send, err := r.guardUpdate(ctx, filename, values)
 	if err != nil {
 		return nil, err
 	}
-	return r.checkResponse()
+	if !send {
+		resp := guardedResponse
+		return &resp, nil
+	}
+
+	resp, err := r.exec(ctx, "UPDATE", append([]string{filename}, values...)...)
+	r.guardFailed(filename, err)
+	return resp, err
+}
+
+// Pending returns the updates queued for filename and not yet written to disk.
+func (r *Rrdcached) Pending(filename string) ([]string, error) {
+	return r.PendingContext(context.Background(), filename)
 }
 
-func (r *Rrdcached) Pending(filename string) (*Response, error) {
-	err := r.write("PENDING " + filename + "\n")
+func (r *Rrdcached) PendingContext(ctx context.Context, filename string) ([]string, error) {
+	resp, err := r.exec(ctx, "PENDING", filename)
 	if err != nil {
 		return nil, err
 	}
-	return r.checkResponse()
+	return resp.Lines(), nil
 }
 
 func (r *Rrdcached) Forget(filename string) (*Response, error) {
-	err := r.write("FORGET " + filename + "\n")
-	if err != nil {
-		return nil, err
-	}
-	return r.checkResponse()
+	return r.ForgetContext(context.Background(), filename)
+}
+
+func (r *Rrdcached) ForgetContext(ctx context.Context, filename string) (*Response, error) {
+	return r.exec(ctx, "FORGET", filename)
 }
 
 func (r *Rrdcached) Flush(filename string) (*Response, error) {
-	err := r.write("FLUSH " + filename + "\n")
-	if err != nil {
-		return nil, err
-	}
-	return r.checkResponse()
+	return r.FlushContext(context.Background(), filename)
+}
+
+func (r *Rrdcached) FlushContext(ctx context.Context, filename string) (*Response, error) {
+	return r.exec(ctx, "FLUSH", filename)
+}
+
+// Wrote tells the daemon that filename has been written to disk by someone else,
+// as a journal replay does, so that its queued values are dropped.
+func (r *Rrdcached) Wrote(filename string) (*Response, error) {
+	return r.WroteContext(context.Background(), filename)
+}
+
+func (r *Rrdcached) WroteContext(ctx context.Context, filename string) (*Response, error) {
+	return r.exec(ctx, "WROTE", filename)
 }
 
 func (r *Rrdcached) FlushAll() (*Response, error) {
-	err := r.write("FLUSHALL\n")
-	if err != nil {
-		return nil, err
-	}
-	return r.checkResponse()
+	return r.FlushAllContext(context.Background())
+}
+
+func (r *Rrdcached) FlushAllContext(ctx context.Context) (*Response, error) {
+	return r.exec(ctx, "FLUSHALL")
 }
 
 func (r *Rrdcached) First(filename string, rraIndex int) (*Response, error) {
-	err := r.write("FIRST " + filename + " " + strconv.Itoa(rraIndex) + "\n")
-	if err != nil {
-		return nil, err
-	}
-	return r.checkResponse()
+	return r.FirstContext(context.Background(), filename, rraIndex)
+}
+
+func (r *Rrdcached) FirstContext(ctx context.Context, filename string, rraIndex int) (*Response, error) {
+	return r.exec(ctx, "FIRST", filename, strconv.Itoa(rraIndex))
 }
 
 func (r *Rrdcached) Last(filename string) (*Response, error) {
-	err := r.write("LAST " + filename + "\n")
-	if err != nil {
-		return nil, err
-	}
-	return r.checkResponse()
+	return r.LastContext(context.Background(), filename)
+}
+
+func (r *Rrdcached) LastContext(ctx context.Context, filename string) (*Response, error) {
+	return r.exec(ctx, "LAST", filename)
 }
 
 func (r *Rrdcached) Quit() {
+	r.lock()
+	defer r.mu.Unlock()
+
 	r.write("QUIT\n")
 }
@@ -1,9 +1,13 @@
 package rrdcached
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"net"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -32,6 +36,41 @@ func prepTestData(fakeWritten string, fakeResponse string) (expected *fakeDataTr
 	return expected, fakeDriver
 }
 
+// fakeEchoTransport answers each command with the command itself, so a reply
+// handed to the wrong caller shows up as a mismatch. It deliberately has no
+// locking of its own: the race detector flags any unserialized access.
+type fakeEchoTransport struct {
+	pending []string
+}
+
+func (rrdio *fakeEchoTransport) WriteData(conn net.Conn, data string) error {
+	for _, line := range strings.Split(strings.TrimSuffix(data, "\n"), "\n") {
+		rrdio.pending = append(rrdio.pending, line)
+	}
+	return nil
+}
+
+func (rrdio *fakeEchoTransport) ReadData(r io.Reader) (string, error) {
+	if len(rrdio.pending) == 0 {
+		return "", io.EOF
+	}
+	command := rrdio.pending[0]
+	rrdio.pending = rrdio.pending[1:]
+
+	if command == "BATCH" {
+		return "0 Go ahead.  End with dot '.' on its own line.", nil
+	}
+	if strings.HasPrefix(command, "UPDATE batch-") {
+		// Everything up to the dot belongs to the batch, which is answered once.
+		for len(rrdio.pending) > 0 && command != "." {
+			command = rrdio.pending[0]
+			rrdio.pending = rrdio.pending[1:]
+		}
+		return "0 errors", nil
+	}
+	return "0 " + command, nil
+}
+
 // ------------------------------------------
 // Tests
 
@@ -161,16 +200,28 @@ func TestUpdateWithoutExistingRRD(t *testing.T) {
 func TestPending(t *testing.T) {
 	expected, fakeDriver := prepTestData(
 		"PENDING foo.rrd\n",
-		"4 updates pending",
+		"2 updates pending\n1438354679:10:20:30:40\n1438354680:90:80:70:60\n",
 	)
 
-	resp, err := fakeDriver.Pending("foo.rrd")
+	updates, err := fakeDriver.Pending("foo.rrd")
 
 	assert.NoError(t, err)
-	assert.Equal(t, 4, resp.Status)
+	assert.Equal(t, []string{"1438354679:10:20:30:40", "1438354680:90:80:70:60"}, updates)
 	assert.Equal(t, expected, fakeDriver.Rrdio)
 }
 
+func TestPendingNone(t *testing.T) {
+	_, fakeDriver := prepTestData(
+		"PENDING foo.rrd\n",
+		"0 updates pending",
+	)
+
+	updates, err := fakeDriver.Pending("foo.rrd")
+
+	assert.NoError(t, err)
+	assert.Empty(t, updates)
+}
+
 func TestForget(t *testing.T) {
 	expected, fakeDriver := prepTestData(
 		"FORGET foo.rrd\n",
@@ -197,6 +248,32 @@ func TestFlush(t *testing.T) {
 	assert.Equal(t, expected, fakeDriver.Rrdio)
 }
 
+func TestWrote(t *testing.T) {
+	expected, fakeDriver := prepTestData(
+		"WROTE foo.rrd\n",
+		"0 Wrote foo.rrd",
+	)
+
+	resp, err := fakeDriver.Wrote("foo.rrd")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, resp.Status)
+	assert.Equal(t, expected, fakeDriver.Rrdio)
+}
+
+func TestWroteWithoutExistingRRD(t *testing.T) {
+	expected, fakeDriver := prepTestData(
+		"WROTE foo.rrd\n",
+		"-1 No such file: /tmp/foo.rrd",
+	)
+
+	resp, err := fakeDriver.Wrote("foo.rrd")
+
+	assert.IsType(t, &FileDoesNotExistError{}, err)
+	assert.Equal(t, -1, resp.Status)
+	assert.Equal(t, expected, fakeDriver.Rrdio)
+}
+
 func TestFlushAll(t *testing.T) {
 	expected, fakeDriver := prepTestData(
 		"FLUSHALL\n",
@@ -255,3 +332,152 @@ func TestStats(t *testing.T) {
 	assert.Equal(t, 23, stats.JournalBytes)
 	assert.Equal(t, 29, stats.JournalRotate)
 }
+
+func TestConcurrentCommands(t *testing.T) {
+	fakeDriver := &Rrdcached{Rrdio: &fakeEchoTransport{}}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 20; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				filename := fmt.Sprintf("foo-%d.rrd", g)
+				value := fmt.Sprintf("%d:%d", i, g)
+
+				resp, err := fakeDriver.Update(filename, value)
+				assert.NoError(t, err)
+				assert.Equal(t, "UPDATE "+filename+" "+value, resp.Message)
+
+				resp, err = fakeDriver.Flush(filename)
+				assert.NoError(t, err)
+				assert.Equal(t, "FLUSH "+filename, resp.Message)
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestConcurrentBatches(t *testing.T) {
+	fakeDriver := &Rrdcached{Rrdio: &fakeEchoTransport{}}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 10; g++ {
+		wg.Add(2)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				resp, err := fakeDriver.Batch().
+					Update(fmt.Sprintf("batch-%d.rrd", g), fmt.Sprintf("%d:1", i)).
+					Update(fmt.Sprintf("batch-%d.rrd", g), fmt.Sprintf("%d:2", i+1)).
+					Exec()
+				assert.NoError(t, err)
+				assert.Equal(t, "errors", resp.Message)
+			}
+		}(g)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				resp, err := fakeDriver.Forget(fmt.Sprintf("foo-%d.rrd", g))
+				assert.NoError(t, err)
+				assert.Equal(t, fmt.Sprintf("FORGET foo-%d.rrd", g), resp.Message)
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestReadData(t *testing.T) {
+	in := bufio.NewReader(strings.NewReader("2 Pending updates\n1438354679:10\n1438354680:20\n0 Nothing to do\n-1 No such file: /tmp/foo.rrd\n"))
+	transport := dataTransport{}
+
+	data, err := transport.ReadData(in)
+	assert.NoError(t, err)
+	assert.Equal(t, "2 Pending updates\n1438354679:10\n1438354680:20\n", data)
+
+	data, err = transport.ReadData(in)
+	assert.NoError(t, err)
+	assert.Equal(t, "0 Nothing to do\n", data)
+
+	data, err = transport.ReadData(in)
+	assert.NoError(t, err)
+	assert.Equal(t, "-1 No such file: /tmp/foo.rrd\n", data)
+
+	_, err = transport.ReadData(in)
+	assert.IsType(t, &ConnectionError{}, err)
+}
+
+func TestReadDataTruncated(t *testing.T) {
+	in := bufio.NewReader(strings.NewReader("3 Pending updates\n1438354679:10\n"))
+
+	_, err := dataTransport{}.ReadData(in)
+
+	assert.IsType(t, &ConnectionError{}, err)
+}
+
+// legacyReadData is the 1 KB read loop ReadData replaced, kept for comparison.
+func legacyReadData(r io.Reader) (string, error) {
+	data := ""
+
+	for {
+		buf := make([]byte, 1024)
+		n, err := r.Read(buf[:])
+		if err != nil {
+			return "", checkError(err)
+		}
+		data += string(buf[0:n])
+
+		check := strings.Split(data, " ")
+		if len(check) > 1 {
+			status, err := strconv.ParseUint(check[0], 10, 64)
+			if err != nil {
+				break
+			}
+			if status <= 0 {
+				break
+			}
+			lines := strings.Split(data, "\n")
+			if uint64(len(lines)) >= (status + 1) {
+				break
+			}
+		}
+	}
+
+	return data, nil
+}
+
+func largeReply(lines int) string {
+	var reply strings.Builder
+	fmt.Fprintf(&reply, "%d updates pending\n", lines)
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&reply, "%d:%d:%d\n", 1438354679+i, i, 2*i)
+	}
+	return reply.String()
+}
+
+func BenchmarkReadData(b *testing.B) {
+	reply := largeReply(10000)
+	transport := dataTransport{}
+	b.SetBytes(int64(len(reply)))
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		_, err := transport.ReadData(bufio.NewReader(strings.NewReader(reply)))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLegacyReadData(b *testing.B) {
+	reply := largeReply(10000)
+	b.SetBytes(int64(len(reply)))
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		_, err := legacyReadData(strings.NewReader(reply))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
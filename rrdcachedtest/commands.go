@@ -0,0 +1,408 @@
+package rrdcachedtest
+
+import (
+	"fmt"
+	"math/bits"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rrd is the part of an RRD the server keeps: its definition and the updates it received.
+type rrd struct {
+	step       int64
+	lastUpdate int64
+	ds         []string
+	rra        []string
+	pending    []string
+	written    []string
+	suspended  bool
+}
+
+type stats struct {
+	creates         uint64
+	updatesReceived uint64
+	flushesReceived uint64
+	updatesWritten  uint64
+	dataSetsWritten uint64
+}
+
+// usage is the reply to HELP, and to commands given the wrong number of arguments.
+var usage = []struct {
+	command string
+	usage   string
+}{
+	{"UPDATE", "UPDATE <filename> <values> [<values> ...]"},
+	{"FLUSH", "FLUSH <filename>"},
+	{"FLUSHALL", "FLUSHALL"},
+	{"PENDING", "PENDING <filename>"},
+	{"FORGET", "FORGET <filename>"},
+	{"QUEUE", "QUEUE"},
+	{"STATS", "STATS"},
+	{"HELP", "HELP [<command>]"},
+	{"BATCH", "BATCH"},
+	{"QUIT", "QUIT"},
+	{"WROTE", "WROTE <filename>"},
+	{"FIRST", "FIRST <filename> <rra index>"},
+	{"LAST", "LAST <filename>"},
+	{"INFO", "INFO <filename>"},
+	{"CREATE", "CREATE <filename> [-b start] [-s step] [-O] <DS definitions> <RRA definitions>"},
+	{"LIST", "LIST [RECURSIVE] <path>"},
+	{"SUSPEND", "SUSPEND <filename>"},
+	{"RESUME", "RESUME <filename>"},
+	{"SUSPENDALL", "SUSPENDALL"},
+	{"RESUMEALL", "RESUMEALL"},
+}
+
+func usageOf(command string) string {
+	for _, u := range usage {
+		if u.command == command {
+			return "-1 Usage: " + u.usage + "\n"
+		}
+	}
+	return "-1 Unknown command: " + command + "\n"
+}
+
+// reply formats a status line followed by lines, the status being the number of lines.
+func reply(message string, lines ...string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d %s\n", len(lines), message)
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func noSuchFile(filename string) string {
+	return "-1 No such file: " + filename + "\n"
+}
+
+// run handles a command other than BATCH and QUIT. The caller holds s.mu.
+func (s *Server) run(command string, args []string) string {
+	switch command {
+	case "HELP":
+		return s.help()
+	case "CREATE":
+		return s.create(args)
+	case "UPDATE":
+		return s.update(args)
+	case "STATS":
+		return s.statistics()
+	case "FLUSHALL":
+		for _, file := range s.files {
+			s.flush(file)
+		}
+		return "0 Started flush.\n"
+	case "QUEUE":
+		return s.queue()
+	case "LIST":
+		return s.list(args)
+	case "SUSPENDALL":
+		for _, file := range s.files {
+			file.suspended = true
+		}
+		return fmt.Sprintf("0 Suspended %d file(s)\n", len(s.files))
+	case "RESUMEALL":
+		for _, file := range s.files {
+			file.suspended = false
+		}
+		return fmt.Sprintf("0 Resumed %d file(s)\n", len(s.files))
+	}
+
+	switch command {
+	case "FLUSH", "PENDING", "FORGET", "WROTE", "LAST", "INFO", "SUSPEND", "RESUME", "FIRST":
+	default:
+		return usageOf(command)
+	}
+	if len(args) < 1 {
+		return usageOf(command)
+	}
+	filename := args[0]
+
+	file, ok := s.files[filename]
+	if !ok {
+		return noSuchFile(filename)
+	}
+
+	switch command {
+	case "FLUSH":
+		s.stats.flushesReceived++
+		if len(file.pending) == 0 {
+			return "0 Nothing to flush: " + filename + ".\n"
+		}
+		s.flush(file)
+		return "0 Successfully flushed " + filename + ".\n"
+	case "PENDING":
+		return reply("updates pending", file.pending...)
+	case "FORGET":
+		file.pending = nil
+		return "0 Gone!\n"
+	case "WROTE":
+		return "0 Wrote " + filename + "\n"
+	case "LAST":
+		return fmt.Sprintf("0 %d\n", file.lastUpdate)
+	case "FIRST":
+		return s.first(file, args)
+	case "INFO":
+		return s.info(filename, file)
+	case "SUSPEND":
+		if file.suspended {
+			return "-1 File is already suspended: " + filename + "\n"
+		}
+		file.suspended = true
+		return "0 Suspended " + filename + "\n"
+	default: // RESUME
+		if !file.suspended {
+			return "-1 File is not suspended: " + filename + "\n"
+		}
+		file.suspended = false
+		return "0 Resumed " + filename + "\n"
+	}
+}
+
+func (s *Server) help() string {
+	lines := make([]string, len(usage))
+	for i, u := range usage {
+		lines[i] = u.usage
+	}
+	return reply("Command overview", lines...)
+}
+
+// create parses CREATE <filename> [-b start] [-s step] [-O] <DS definitions> <RRA definitions>.
+func (s *Server) create(args []string) string {
+	if len(args) < 1 {
+		return usageOf("CREATE")
+	}
+	filename := args[0]
+	file := &rrd{step: 300, lastUpdate: time.Now().Unix() - 10}
+	overwrite := true
+
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-b" || arg == "-s":
+			if i+1 == len(args) {
+				return "-1 Error while creating rrd (can't parse argument '" + arg + "')\n"
+			}
+			i++
+			value, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil || value < 0 {
+				return "-1 Error while creating rrd (can't parse argument '" + args[i] + "')\n"
+			}
+			if arg == "-b" {
+				file.lastUpdate = value
+			} else {
+				file.step = value
+			}
+		case arg == "-O":
+			overwrite = false
+		case strings.HasPrefix(arg, "DS:"):
+			file.ds = append(file.ds, arg)
+		case strings.HasPrefix(arg, "RRA:"):
+			file.rra = append(file.rra, arg)
+		default:
+			return "-1 Error while creating rrd (can't parse argument '" + arg + "')\n"
+		}
+	}
+
+	if len(file.ds) == 0 {
+		return "-1 RRD Error: you must define at least one Data Source\n"
+	}
+	if len(file.rra) == 0 {
+		return "-1 RRD Error: you must define at least one Round Robin Archive\n"
+	}
+	if _, exists := s.files[filename]; exists && !overwrite {
+		return "-1 RRD Error: creating '" + filename + "': File exists\n"
+	}
+
+	s.files[filename] = file
+	s.stats.creates++
+	return "0 RRD created successfully (" + filename + ")\n"
+}
+
+// update enqueues values of the form <timestamp|N>:<value>[:<value>...], which must be in order.
+// As rrdcached does, it stops at the first value it refuses, keeping those before it.
+func (s *Server) update(args []string) string {
+	if len(args) < 2 {
+		return usageOf("UPDATE")
+	}
+	filename := args[0]
+	file, ok := s.files[filename]
+	if !ok {
+		return noSuchFile(filename)
+	}
+	s.stats.updatesReceived++
+
+	for _, value := range args[1:] {
+		stamp, _, found := strings.Cut(value, ":")
+		var timestamp float64
+		var err error
+		if stamp == "N" {
+			timestamp = float64(time.Now().UnixNano()) / float64(time.Second)
+		} else {
+			timestamp, err = strconv.ParseFloat(stamp, 64)
+		}
+		if !found || err != nil {
+			return fmt.Sprintf("-1 Cannot find timestamp in '%s'!\n", value)
+		}
+		if int64(timestamp) <= file.lastUpdate {
+			return fmt.Sprintf("-1 illegal attempt to update using time %d when last update time is %d (minimum one second step)\n",
+				int64(timestamp), file.lastUpdate)
+		}
+		file.lastUpdate = int64(timestamp)
+		file.pending = append(file.pending, value)
+	}
+
+	return fmt.Sprintf("0 errors, enqueued %d value(s).\n", len(args)-1)
+}
+
+// flush writes the pending updates of file, unless it is suspended. The caller holds s.mu.
+func (s *Server) flush(file *rrd) {
+	if file.suspended || len(file.pending) == 0 {
+		return
+	}
+	s.stats.updatesWritten++
+	s.stats.dataSetsWritten += uint64(len(file.pending))
+	file.written = append(file.written, file.pending...)
+	file.pending = nil
+}
+
+func (s *Server) statistics() string {
+	queued := 0
+	for _, file := range s.files {
+		if len(file.pending) > 0 {
+			queued++
+		}
+	}
+	return reply("Statistics follow",
+		fmt.Sprintf("QueueLength: %d", queued),
+		fmt.Sprintf("CreatesReceived: %d", s.stats.creates),
+		fmt.Sprintf("UpdatesReceived: %d", s.stats.updatesReceived),
+		fmt.Sprintf("FlushesReceived: %d", s.stats.flushesReceived),
+		fmt.Sprintf("UpdatesWritten: %d", s.stats.updatesWritten),
+		fmt.Sprintf("DataSetsWritten: %d", s.stats.dataSetsWritten),
+		fmt.Sprintf("TreeNodesNumber: %d", len(s.files)),
+		fmt.Sprintf("TreeDepth: %d", bits.Len(uint(len(s.files)))),
+		"JournalBytes: 0",
+		"JournalRotate: 0",
+	)
+}
+
+func (s *Server) queue() string {
+	var lines []string
+	for _, filename := range s.filenames() {
+		if pending := len(s.files[filename].pending); pending > 0 {
+			lines = append(lines, fmt.Sprintf("%d %s", pending, filename))
+		}
+	}
+	return reply("in queue", lines...)
+}
+
+func (s *Server) list(args []string) string {
+	recursive := len(args) == 2 && args[0] == "RECURSIVE"
+	if len(args) != 1 && !recursive {
+		return usageOf("LIST")
+	}
+	dir := path.Clean(args[len(args)-1])
+
+	var lines []string
+	for _, filename := range s.filenames() {
+		parent := path.Dir(filename)
+		if parent == dir || recursive && strings.HasPrefix(parent, strings.TrimSuffix(dir, "/")+"/") {
+			lines = append(lines, filename)
+		}
+	}
+	return reply("RRDs", lines...)
+}
+
+func (s *Server) filenames() []string {
+	filenames := make([]string, 0, len(s.files))
+	for filename := range s.files {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	return filenames
+}
+
+// first returns the oldest timestamp an RRA can hold, given the last update and its size.
+func (s *Server) first(file *rrd, args []string) string {
+	index := 0
+	if len(args) > 1 {
+		var err error
+		index, err = strconv.Atoi(args[1])
+		if err != nil {
+			return "-1 Usage: FIRST <filename> <rra index>\n"
+		}
+	}
+	if index < 0 || index >= len(file.rra) {
+		return "-1 Invalid RRA index: " + strconv.Itoa(index) + "\n"
+	}
+
+	_, _, pdpPerRow, rows := parseRRA(file.rra[index])
+	span := file.step * pdpPerRow
+	return fmt.Sprintf("0 %d\n", file.lastUpdate-file.lastUpdate%span-(rows-1)*span)
+}
+
+// parseRRA splits RRA:<cf>:<xff>:<steps>:<rows>. Malformed numbers count as 1.
+func parseRRA(definition string) (string, string, int64, int64) {
+	fields := strings.Split(definition, ":")
+	for len(fields) < 5 {
+		fields = append(fields, "")
+	}
+	pdpPerRow, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil || pdpPerRow < 1 {
+		pdpPerRow = 1
+	}
+	rows, err := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil || rows < 1 {
+		rows = 1
+	}
+	return fields[1], fields[2], pdpPerRow, rows
+}
+
+// info reports the header fields that the server knows, in the "<key> <type> <value>" form of rrd_info.
+func (s *Server) info(filename string, file *rrd) string {
+	lines := []string{
+		"filename 2 " + filename,
+		"rrd_version 2 0003",
+		fmt.Sprintf("step 1 %d", file.step),
+		fmt.Sprintf("last_update 1 %d", file.lastUpdate),
+	}
+	for i, definition := range file.ds {
+		// DS:<name>:<type>:<heartbeat>:<min>:<max>
+		fields := strings.Split(definition, ":")
+		for len(fields) < 6 {
+			fields = append(fields, "U")
+		}
+		key := "ds[" + fields[1] + "]."
+		lines = append(lines,
+			fmt.Sprintf("%sindex 1 %d", key, i),
+			key+"type 2 "+fields[2],
+			key+"minimal_heartbeat 1 "+fields[3],
+			key+"min 0 "+infoValue(fields[4]),
+			key+"max 0 "+infoValue(fields[5]),
+		)
+	}
+	for i, definition := range file.rra {
+		cf, xff, pdpPerRow, rows := parseRRA(definition)
+		key := fmt.Sprintf("rra[%d].", i)
+		lines = append(lines,
+			key+"cf 2 "+cf,
+			fmt.Sprintf("%srows 1 %d", key, rows),
+			fmt.Sprintf("%spdp_per_row 1 %d", key, pdpPerRow),
+			key+"xff 0 "+infoValue(xff),
+		)
+	}
+	return reply("Info for "+filename+" follows", lines...)
+}
+
+func infoValue(value string) string {
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return "nan"
+	}
+	return strconv.FormatFloat(number, 'e', 10, 64)
+}
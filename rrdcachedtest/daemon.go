@@ -0,0 +1,199 @@
+package rrdcachedtest
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/dreadpirateshawn/rrdcached"
+)
+
+// DaemonOptions configures StartDaemon. The zero value starts rrdcached from PATH on a unix socket.
+type DaemonOptions struct {
+	// Binary is the rrdcached executable. Defaults to "rrdcached", looked up in PATH.
+	Binary string
+	// TCP makes the daemon also listen on a free port of the loopback interface.
+	TCP bool
+	// Args are passed to the daemon after those of the harness, e.g. "-w", "3600".
+	Args []string
+	// Timeout limits the wait for the daemon to accept connections. Defaults to 30 seconds.
+	Timeout time.Duration
+	// ClientOptions configure Daemon.Client.
+	ClientOptions []rrdcached.Option
+}
+
+// Daemon is an rrdcached started by StartDaemon.
+type Daemon struct {
+	// BaseDir is the daemon's base directory. Relative filenames are resolved against it,
+	// and absolute ones outside of it are refused.
+	BaseDir string
+	// Socket is the path of the daemon's unix socket.
+	Socket string
+	// Port is the TCP port the daemon listens on, or 0 without DaemonOptions.TCP.
+	Port int
+	// Client is connected to Socket.
+	Client *rrdcached.Rrdcached
+
+	cmd    *exec.Cmd
+	output *lockedBuffer
+	exited chan struct{}
+}
+
+// StartDaemon runs rrdcached in the foreground with a temporary base directory, waits until it
+// accepts connections and connects Daemon.Client to it. The test is skipped if the binary is not
+// installed. The client is closed and the daemon stopped with SIGTERM when the test finishes.
+func StartDaemon(t testing.TB, opts DaemonOptions) *Daemon {
+	t.Helper()
+
+	if opts.Binary == "" {
+		opts.Binary = "rrdcached"
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	binary, err := exec.LookPath(opts.Binary)
+	if err != nil {
+		t.Skipf("rrdcached is not installed: %v", err)
+	}
+
+	// t.TempDir can be too long for a unix socket path.
+	dir, err := os.MkdirTemp("", "rrdcached")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	d := &Daemon{
+		BaseDir: filepath.Join(dir, "base"),
+		Socket:  filepath.Join(dir, "rrdcached.sock"),
+		output:  &lockedBuffer{},
+		exited:  make(chan struct{}),
+	}
+	err = os.Mkdir(d.BaseDir, 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// -g keeps the daemon in the foreground, otherwise it forks and leaves no process to stop.
+	args := []string{"-g",
+		"-R",
+		"-p", filepath.Join(dir, "rrdcached.pid"),
+		"-B", "-b", d.BaseDir,
+		"-l", "unix:" + d.Socket}
+	if opts.TCP {
+		d.Port, err = freePort()
+		if err != nil {
+			t.Fatal(err)
+		}
+		args = append(args, "-l", net.JoinHostPort("127.0.0.1", strconv.Itoa(d.Port)))
+	}
+	args = append(args, opts.Args...)
+
+	d.cmd = exec.Command(binary, args...)
+	d.cmd.Stdout = d.output
+	d.cmd.Stderr = d.output
+	err = d.cmd.Start()
+	if err != nil {
+		t.Fatalf("rrdcached failed to start: %v", err)
+	}
+	go func() {
+		d.cmd.Wait()
+		close(d.exited)
+	}()
+	t.Cleanup(d.stop)
+
+	err = d.waitReady(opts.Timeout)
+	if err != nil {
+		t.Fatalf("rrdcached %v: %v\n%s", args, err, d.output.String())
+	}
+
+	d.Client, err = rrdcached.New("unix:"+d.Socket, opts.ClientOptions...)
+	if err != nil {
+		t.Fatalf("connecting to rrdcached: %v", err)
+	}
+	t.Cleanup(func() { d.Client.Quit() })
+
+	return d
+}
+
+// Address is the address of the daemon's unix socket in the form accepted by rrdcached.Connect.
+func (d *Daemon) Address() string {
+	return "unix:" + d.Socket
+}
+
+// freePort finds a port that nothing listens on. Another process may take it before the daemon does,
+// but that is unlikely on the loopback interface.
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// waitReady polls the daemon's sockets until they accept connections.
+func (d *Daemon) waitReady(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	addresses := [][2]string{{"unix", d.Socket}}
+	if d.Port != 0 {
+		addresses = append(addresses, [2]string{"tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(d.Port))})
+	}
+
+	for _, address := range addresses {
+		for {
+			conn, err := net.DialTimeout(address[0], address[1], time.Second)
+			if err == nil {
+				conn.Close()
+				break
+			}
+			select {
+			case <-d.exited:
+				return fmt.Errorf("exited before listening on %s", address[1])
+			default:
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("not listening on %s after %v: %v", address[1], timeout, err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	return nil
+}
+
+// stop asks the daemon to flush and exit, and kills it if it takes too long.
+func (d *Daemon) stop() {
+	d.cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-d.exited:
+	case <-time.After(10 * time.Second):
+		d.cmd.Process.Kill()
+		<-d.exited
+	}
+}
+
+// lockedBuffer collects the daemon's output, which exec writes from its own goroutines.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
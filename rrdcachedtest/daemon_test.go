@@ -0,0 +1,36 @@
+package rrdcachedtest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dreadpirateshawn/rrdcached"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStartDaemon(t *testing.T) {
+	daemon := StartDaemon(t, DaemonOptions{TCP: true})
+
+	_, err := daemon.Client.Create("foo.rrd", -1, 60, true,
+		[]string{"DS:test1:GAUGE:600:0:100"}, []string{"RRA:AVERAGE:0.5:1:10"})
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(daemon.BaseDir, "foo.rrd"))
+	assert.NoError(t, err)
+
+	client, err := rrdcached.ConnectToIP("127.0.0.1", int64(daemon.Port))
+	assert.NoError(t, err)
+	defer client.Quit()
+	_, err = client.Update("foo.rrd", rrdcached.NowString()+":10")
+	assert.NoError(t, err)
+}
+
+func TestStartDaemonWithoutBinary(t *testing.T) {
+	var skipped bool
+	t.Run("missing", func(t *testing.T) {
+		defer func() { skipped = t.Skipped() }()
+		StartDaemon(t, DaemonOptions{Binary: "rrdcached-not-installed"})
+	})
+
+	assert.True(t, skipped)
+}
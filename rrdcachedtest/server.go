@@ -0,0 +1,309 @@
+// Package rrdcachedtest provides an in-process fake of rrdcached, so that code using the rrdcached
+// package can be tested without installing rrdtool:
+//
+//	server, err := rrdcachedtest.NewServer("unix")
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer server.Close()
+//	client, err := rrdcached.Connect(server.Address())
+//
+// The server speaks the daemon's line protocol and keeps the RRDs it is told to create in memory.
+// It does not consolidate data, so FETCH, FETCHBIN and the journal are not supported.
+// Faults can be scripted with Inject to test how callers cope with errors, slow replies and hang-ups.
+package rrdcachedtest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dreadpirateshawn/rrdcached/internal/protocol"
+)
+
+// Fault changes how the server answers the commands it matches.
+// Within a BATCH each command is matched on its own, and a Reply starting with "-1 " is reported
+// as an error of the batch.
+type Fault struct {
+	// Command is the command to match, e.g. "UPDATE". Empty matches every command.
+	Command string
+	// Count is the number of matching commands the fault applies to. Zero applies it to all of them.
+	Count int
+	// Delay is waited before the command is handled.
+	Delay time.Duration
+	// Reply, if set, is sent instead of handling the command, e.g. "-1 Timeout".
+	Reply string
+	// Drop closes the connection instead of handling the command.
+	Drop bool
+}
+
+type fault struct {
+	Fault
+	used int
+}
+
+// Server is a fake rrdcached. It is safe for concurrent use by any number of connections.
+type Server struct {
+	listener net.Listener
+	network  string
+	dir      string
+
+	mu       sync.Mutex
+	files    map[string]*rrd
+	stats    stats
+	faults   []*fault
+	received []string
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewServer starts a server listening on a unix socket in a temporary directory when network
+// is "unix", or on a free port of the loopback interface when network is "tcp".
+func NewServer(network string) (*Server, error) {
+	s := &Server{
+		network: network,
+		files:   map[string]*rrd{},
+		conns:   map[net.Conn]struct{}{},
+	}
+
+	var err error
+	switch network {
+	case "unix":
+		s.dir, err = os.MkdirTemp("", "rrdcachedtest")
+		if err != nil {
+			return nil, err
+		}
+		s.listener, err = net.Listen("unix", filepath.Join(s.dir, "rrdcached.sock"))
+		if err != nil {
+			os.RemoveAll(s.dir)
+			return nil, err
+		}
+	case "tcp":
+		s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported network %q", network)
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Address is the address of the server in the form accepted by rrdcached.Connect.
+func (s *Server) Address() string {
+	if s.network == "unix" {
+		return "unix:" + s.listener.Addr().String()
+	}
+	return s.listener.Addr().String()
+}
+
+// Close stops the server and hangs up on its clients.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	err := s.listener.Close()
+	s.wg.Wait()
+	if s.dir != "" {
+		os.RemoveAll(s.dir)
+	}
+	return err
+}
+
+// Inject adds faults, which are matched in the order they were added.
+func (s *Server) Inject(faults ...Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, f := range faults {
+		f.Command = strings.ToUpper(f.Command)
+		s.faults = append(s.faults, &fault{Fault: f})
+	}
+}
+
+// ClearFaults removes the faults added by Inject.
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = nil
+}
+
+// Received returns the command lines received so far, including those inside batches.
+func (s *Server) Received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.received...)
+}
+
+// Pending returns the updates of filename that have not been flushed.
+func (s *Server) Pending(filename string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if file, ok := s.files[filename]; ok {
+		return append([]string(nil), file.pending...)
+	}
+	return nil
+}
+
+// Written returns the updates of filename that have been flushed, in the order they were received.
+func (s *Server) Written(filename string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if file, ok := s.files[filename]; ok {
+		return append([]string(nil), file.written...)
+	}
+	return nil
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// handle answers the commands sent on conn until the client quits or hangs up.
+func (s *Server) handle(conn net.Conn) {
+	in := bufio.NewReader(conn)
+	for {
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			continue
+		}
+
+		reply, ok := s.command(line, in, conn)
+		if !ok {
+			return
+		}
+		_, err = conn.Write([]byte(reply))
+		if err != nil {
+			return
+		}
+	}
+}
+
+// command runs line and returns its reply. It reports false if the connection must be closed.
+func (s *Server) command(line string, in *bufio.Reader, conn net.Conn) (string, bool) {
+	command, args := splitCommand(line)
+
+	f := s.receive(line, command)
+	if f != nil {
+		time.Sleep(f.Delay)
+		if f.Drop {
+			return "", false
+		}
+		if f.Reply != "" {
+			return strings.TrimRight(f.Reply, "\n") + "\n", true
+		}
+	}
+
+	switch command {
+	case "QUIT":
+		return "", false
+	case "BATCH":
+		return s.batch(in, conn)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.run(command, args), true
+}
+
+// receive records line and returns the fault that applies to it, if any.
+func (s *Server) receive(line string, command string) *fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.received = append(s.received, line)
+	for _, f := range s.faults {
+		if (f.Command == "" || f.Command == command) && (f.Count == 0 || f.used < f.Count) {
+			f.used++
+			return f
+		}
+	}
+	return nil
+}
+
+// batch reads commands up to the terminating dot and reports the line numbers of those that failed.
+func (s *Server) batch(in *bufio.Reader, conn net.Conn) (string, bool) {
+	_, err := conn.Write([]byte("0 Go ahead.  End with dot '.' on its own line.\n"))
+	if err != nil {
+		return "", false
+	}
+
+	var errors []string
+	for n := 1; ; n++ {
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return "", false
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "." {
+			break
+		}
+
+		reply, ok := s.command(line, in, conn)
+		if !ok {
+			return "", false
+		}
+		if strings.HasPrefix(reply, "-1 ") {
+			errors = append(errors, fmt.Sprintf("%d %s", n, strings.TrimPrefix(reply, "-1 ")))
+		}
+	}
+
+	return fmt.Sprintf("%d errors\n", len(errors)) + strings.Join(errors, ""), true
+}
+
+// splitCommand splits line into the command, in upper case, and its arguments, as rrdcached does.
+func splitCommand(line string) (string, []string) {
+	fields := protocol.Fields(line)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return strings.ToUpper(fields[0]), fields[1:]
+}
@@ -0,0 +1,235 @@
+package rrdcachedtest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dreadpirateshawn/rrdcached"
+	"github.com/stretchr/testify/assert"
+)
+
+func startServer(t *testing.T, network string) (*Server, *rrdcached.Rrdcached) {
+	server, err := NewServer(network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	client, err := rrdcached.Connect(server.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, client
+}
+
+func createFoo(t *testing.T, client *rrdcached.Rrdcached) {
+	_, err := client.Create("/tmp/foo.rrd", 1438354600, 60, false,
+		[]string{"DS:test1:GAUGE:600:0:100"}, []string{"RRA:AVERAGE:0.5:1:10"})
+	assert.NoError(t, err)
+}
+
+func TestUpdateAndFlush(t *testing.T) {
+	for _, network := range []string{"unix", "tcp"} {
+		t.Run(network, func(t *testing.T) {
+			server, client := startServer(t, network)
+			createFoo(t, client)
+
+			resp, err := client.Update("/tmp/foo.rrd", "1438354679:10", "1438354680:20")
+			assert.NoError(t, err)
+			assert.Equal(t, "errors, enqueued 2 value(s).", resp.Message)
+
+			pending, err := client.Pending("/tmp/foo.rrd")
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"1438354679:10", "1438354680:20"}, pending)
+
+			_, err = client.Flush("/tmp/foo.rrd")
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"1438354679:10", "1438354680:20"}, server.Written("/tmp/foo.rrd"))
+			assert.Empty(t, server.Pending("/tmp/foo.rrd"))
+
+			last, err := client.Last("/tmp/foo.rrd")
+			assert.NoError(t, err)
+			assert.Equal(t, "1438354680", last.Message)
+
+			first, err := client.First("/tmp/foo.rrd", 0)
+			assert.NoError(t, err)
+			assert.Equal(t, "1438354140", first.Message)
+		})
+	}
+}
+
+func TestUpdateErrors(t *testing.T) {
+	_, client := startServer(t, "unix")
+	createFoo(t, client)
+
+	_, err := client.Update("/tmp/bar.rrd", "1438354679:10")
+	assert.IsType(t, &rrdcached.FileDoesNotExistError{}, err)
+
+	_, err = client.Update("/tmp/foo.rrd", "1438354600:10")
+	assert.EqualError(t, err, "illegal attempt to update using time 1438354600 when last update time is 1438354600 (minimum one second step)")
+
+	_, err = client.Update("/tmp/foo.rrd", "soon")
+	assert.EqualError(t, err, "Cannot find timestamp in 'soon'!")
+}
+
+func TestFilenameWithSpaces(t *testing.T) {
+	server, client := startServer(t, "unix")
+	filename := `/tmp/my rrds/foo\bar.rrd`
+
+	_, err := client.Create(filename, 1438354600, 60, false,
+		[]string{"DS:test1:GAUGE:600:0:100"}, []string{"RRA:AVERAGE:0.5:1:10"})
+	assert.NoError(t, err)
+	_, err = client.Update(filename, "1438354679:10")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"1438354679:10"}, server.Pending(filename))
+}
+
+// FuzzFilename checks that the server reads back every filename the client sends.
+func FuzzFilename(f *testing.F) {
+	f.Add("/tmp/foo.rrd")
+	f.Add(`/tmp/my rrds/foo\bar.rrd`)
+	f.Add("  foo  .rrd ")
+	f.Add(`foo.rrd\`)
+	f.Add(`\ \\ `)
+
+	f.Fuzz(func(t *testing.T, filename string) {
+		server, client := startServer(t, "unix")
+		_, err := client.Create(filename, 1438354600, 60, true,
+			[]string{"DS:test1:GAUGE:600:0:100"}, []string{"RRA:AVERAGE:0.5:1:10"})
+		var unsafe *rrdcached.UnsafeArgumentError
+		if errors.As(err, &unsafe) {
+			return
+		}
+		if err != nil {
+			t.Fatalf("create %q: %v", filename, err)
+		}
+
+		_, err = client.Update(filename, "1438354679:10")
+		if err != nil {
+			t.Fatalf("update %q: %v", filename, err)
+		}
+		assert.Equal(t, []string{"1438354679:10"}, server.Pending(filename))
+	})
+}
+
+func TestCreateNoOverwrite(t *testing.T) {
+	_, client := startServer(t, "unix")
+	createFoo(t, client)
+
+	_, err := client.Create("/tmp/foo.rrd", -1, -1, false,
+		[]string{"DS:test1:GAUGE:600:0:100"}, []string{"RRA:AVERAGE:0.5:1:10"})
+	assert.EqualError(t, err, "RRD Error: creating '/tmp/foo.rrd': File exists")
+
+	_, err = client.Create("/tmp/foo.rrd", -1, -1, true,
+		[]string{"DS:test1:GAUGE:600:0:100"}, []string{"RRA:AVERAGE:0.5:1:10"})
+	assert.NoError(t, err)
+}
+
+func TestStatsQueueAndList(t *testing.T) {
+	_, client := startServer(t, "unix")
+	createFoo(t, client)
+	_, err := client.Update("/tmp/foo.rrd", "1438354679:10")
+	assert.NoError(t, err)
+
+	stats, err := client.GetStats()
+	assert.NoError(t, err)
+	assert.Equal(t, rrdcached.Stats{QueueLength: 1, CreatesReceived: 1, UpdatesReceived: 1, TreeNodesNumber: 1, TreeDepth: 1}, *stats)
+
+	queue, err := client.Queue()
+	assert.NoError(t, err)
+	assert.Equal(t, []rrdcached.QueueEntry{{Filename: "/tmp/foo.rrd", PendingUpdates: 1}}, queue)
+
+	list, err := client.List("/tmp", false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/tmp/foo.rrd"}, list)
+
+	info, err := client.Info("/tmp/foo.rrd")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(60), info.Step)
+	assert.Equal(t, int64(1438354679), info.LastUpdate)
+	assert.Equal(t, "test1", info.DS[0].Name)
+	assert.Equal(t, uint64(10), info.RRA[0].Rows)
+}
+
+func TestBatch(t *testing.T) {
+	server, client := startServer(t, "unix")
+	createFoo(t, client)
+
+	_, err := client.Batch().
+		Update("/tmp/foo.rrd", "1438354679:10").
+		Update("/tmp/bar.rrd", "1438354679:10").
+		Exec()
+
+	assert.Equal(t, &rrdcached.BatchError{Errors: []*rrdcached.BatchCommandError{
+		{Line: 2, Command: "UPDATE /tmp/bar.rrd 1438354679:10", Message: "No such file: /tmp/bar.rrd"},
+	}}, err)
+	assert.Equal(t, []string{"1438354679:10"}, server.Pending("/tmp/foo.rrd"))
+}
+
+func TestCapabilities(t *testing.T) {
+	_, client := startServer(t, "unix")
+
+	capabilities, err := client.Capabilities()
+
+	assert.NoError(t, err)
+	assert.True(t, capabilities.Supports("BATCH"))
+	assert.True(t, capabilities.CreateNoOverwrite())
+	assert.False(t, capabilities.Supports("FETCH"))
+}
+
+func TestFaultReply(t *testing.T) {
+	server, client := startServer(t, "unix")
+	createFoo(t, client)
+	server.Inject(Fault{Command: "flush", Count: 1, Reply: "-1 No such file: /tmp/foo.rrd"})
+
+	_, err := client.Flush("/tmp/foo.rrd")
+	assert.IsType(t, &rrdcached.FileDoesNotExistError{}, err)
+
+	_, err = client.Flush("/tmp/foo.rrd")
+	assert.NoError(t, err)
+}
+
+func TestFaultDelay(t *testing.T) {
+	server, client := startServer(t, "unix")
+	server.Inject(Fault{Command: "STATS", Delay: 200 * time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := client.GetStatsContext(ctx)
+
+	assert.IsType(t, &rrdcached.TimeoutError{}, err)
+}
+
+func TestFaultDrop(t *testing.T) {
+	server, err := NewServer("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client, err := rrdcached.New(server.Address(), rrdcached.WithReconnect(rrdcached.ReconnectPolicy{MaxAttempts: 1}))
+	assert.NoError(t, err)
+	createFoo(t, client)
+	server.Inject(Fault{Command: "PENDING", Count: 1, Drop: true})
+
+	_, err = client.Pending("/tmp/foo.rrd")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"HELP",
+		"CREATE /tmp/foo.rrd -b 1438354600 -s 60 -O DS:test1:GAUGE:600:0:100 RRA:AVERAGE:0.5:1:10",
+		"PENDING /tmp/foo.rrd",
+		"PENDING /tmp/foo.rrd",
+	}, server.Received())
+}
+
+func TestClose(t *testing.T) {
+	server, client := startServer(t, "unix")
+	server.Close()
+
+	_, err := client.GetStats()
+
+	assert.IsType(t, &rrdcached.ConnectionError{}, err)
+}
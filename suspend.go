@@ -0,0 +1,73 @@
+package rrdcached
+
+import "context"
+
+// Suspend stops the daemon from writing filename to disk until it is resumed.
+// Updates are still accepted and queued in the meantime.
+func (r *Rrdcached) Suspend(filename string) (*Response, error) {
+	return r.SuspendContext(context.Background(), filename)
+}
+
+func (r *Rrdcached) SuspendContext(ctx context.Context, filename string) (*Response, error) {
+	return r.exec(ctx, "SUSPEND", filename)
+}
+
+func (r *Rrdcached) Resume(filename string) (*Response, error) {
+	return r.ResumeContext(context.Background(), filename)
+}
+
+func (r *Rrdcached) ResumeContext(ctx context.Context, filename string) (*Response, error) {
+	return r.exec(ctx, "RESUME", filename)
+}
+
+func (r *Rrdcached) SuspendAll() (*Response, error) {
+	return r.SuspendAllContext(context.Background())
+}
+
+func (r *Rrdcached) SuspendAllContext(ctx context.Context) (*Response, error) {
+	return r.exec(ctx, "SUSPENDALL")
+}
+
+func (r *Rrdcached) ResumeAll() (*Response, error) {
+	return r.ResumeAllContext(context.Background())
+}
+
+func (r *Rrdcached) ResumeAllContext(ctx context.Context) (*Response, error) {
+	return r.exec(ctx, "RESUMEALL")
+}
+
+// WithSuspended calls fn while writes to files are suspended, e.g. to take a consistent backup.
+// Every file that was suspended is resumed afterwards, even if fn returns an error or panics.
+// If suspending one of the files fails, fn is not called.
+func (r *Rrdcached) WithSuspended(files []string, fn func() error) error {
+	return r.WithSuspendedContext(context.Background(), files, fn)
+}
+
+// WithSuspendedContext bounds the suspends by ctx. The resumes are not bounded by it,
+// so that files are not left suspended because ctx ran out while fn was running.
+func (r *Rrdcached) WithSuspendedContext(ctx context.Context, files []string, fn func() error) error {
+	return withSuspended(ctx, r, files, fn)
+}
+
+// withSuspended is WithSuspendedContext for any client, sending each suspend and resume as a command of its own.
+func withSuspended(ctx context.Context, c Client, files []string, fn func() error) (err error) {
+	var suspended []string
+	defer func() {
+		for _, filename := range suspended {
+			_, resumeErr := c.Resume(filename)
+			if resumeErr != nil && err == nil {
+				err = resumeErr
+			}
+		}
+	}()
+
+	for _, filename := range files {
+		_, err = c.SuspendContext(ctx, filename)
+		if err != nil {
+			return err
+		}
+		suspended = append(suspended, filename)
+	}
+
+	return fn()
+}
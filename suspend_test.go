@@ -0,0 +1,138 @@
+package rrdcached
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuspend(t *testing.T) {
+	expected, fakeDriver := prepTestData(
+		"SUSPEND foo.rrd\n",
+		"0 foo.rrd suspended",
+	)
+
+	resp, err := fakeDriver.Suspend("foo.rrd")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, resp.Status)
+	assert.Equal(t, expected, fakeDriver.Rrdio)
+}
+
+func TestResume(t *testing.T) {
+	expected, fakeDriver := prepTestData(
+		"RESUME foo.rrd\n",
+		"0 foo.rrd resumed",
+	)
+
+	resp, err := fakeDriver.Resume("foo.rrd")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, resp.Status)
+	assert.Equal(t, expected, fakeDriver.Rrdio)
+}
+
+func TestSuspendAll(t *testing.T) {
+	expected, fakeDriver := prepTestData(
+		"SUSPENDALL\n",
+		"0 2 rrds suspended",
+	)
+
+	resp, err := fakeDriver.SuspendAll()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, resp.Status)
+	assert.Equal(t, expected, fakeDriver.Rrdio)
+}
+
+func TestResumeAll(t *testing.T) {
+	expected, fakeDriver := prepTestData(
+		"RESUMEALL\n",
+		"0 2 rrds resumed",
+	)
+
+	resp, err := fakeDriver.ResumeAll()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, resp.Status)
+	assert.Equal(t, expected, fakeDriver.Rrdio)
+}
+
+func TestSuspendUnsupported(t *testing.T) {
+	_, fakeDriver := prepTestData(
+		"SUSPEND foo.rrd\n",
+		"-1 Unknown command: SUSPEND",
+	)
+
+	_, err := fakeDriver.Suspend("foo.rrd")
+
+	assert.IsType(t, &UnknownCommandError{}, err)
+}
+
+func TestWithSuspended(t *testing.T) {
+	transport, fakeDriver := prepSequenceTestData(
+		"0 foo.rrd suspended",
+		"0 bar.rrd suspended",
+		"0 foo.rrd resumed",
+		"0 bar.rrd resumed",
+	)
+
+	called := false
+	err := fakeDriver.WithSuspended([]string{"foo.rrd", "bar.rrd"}, func() error {
+		called = true
+		assert.Equal(t, []string{"SUSPEND foo.rrd\n", "SUSPEND bar.rrd\n"}, transport.written)
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.True(t, called)
+	assert.Equal(t, []string{"SUSPEND foo.rrd\n", "SUSPEND bar.rrd\n", "RESUME foo.rrd\n", "RESUME bar.rrd\n"}, transport.written)
+}
+
+func TestWithSuspendedCallbackError(t *testing.T) {
+	transport, fakeDriver := prepSequenceTestData(
+		"0 foo.rrd suspended",
+		"0 foo.rrd resumed",
+	)
+
+	callbackErr := errors.New("backup failed")
+	err := fakeDriver.WithSuspended([]string{"foo.rrd"}, func() error {
+		return callbackErr
+	})
+
+	assert.Equal(t, callbackErr, err)
+	assert.Equal(t, []string{"SUSPEND foo.rrd\n", "RESUME foo.rrd\n"}, transport.written)
+}
+
+func TestWithSuspendedCallbackPanic(t *testing.T) {
+	transport, fakeDriver := prepSequenceTestData(
+		"0 foo.rrd suspended",
+		"0 foo.rrd resumed",
+	)
+
+	assert.Panics(t, func() {
+		fakeDriver.WithSuspended([]string{"foo.rrd"}, func() error {
+			panic("backup exploded")
+		})
+	})
+	assert.Equal(t, []string{"SUSPEND foo.rrd\n", "RESUME foo.rrd\n"}, transport.written)
+}
+
+func TestWithSuspendedSuspendFails(t *testing.T) {
+	transport, fakeDriver := prepSequenceTestData(
+		"0 foo.rrd suspended",
+		"-1 No such file: /tmp/bar.rrd",
+		"0 foo.rrd resumed",
+	)
+
+	called := false
+	err := fakeDriver.WithSuspended([]string{"foo.rrd", "bar.rrd"}, func() error {
+		called = true
+		return nil
+	})
+
+	assert.IsType(t, &FileDoesNotExistError{}, err)
+	assert.False(t, called)
+	assert.Equal(t, []string{"SUSPEND foo.rrd\n", "SUSPEND bar.rrd\n", "RESUME foo.rrd\n"}, transport.written)
+}